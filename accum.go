@@ -0,0 +1,50 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ebarkie/weatherlink/data"
+)
+
+// PutRain sets the yearly rain accumulation in inches.
+func (c Conn) PutRain(in float64) error {
+	return c.putRain(c.brokerContext(), in)
+}
+
+// putRain sets the yearly rain accumulation in inches.
+func (c Conn) putRain(ctx context.Context, in float64) error {
+	p, err := data.YearRain(in).MarshalText()
+	if err != nil {
+		return fmt.Errorf("%w: yearly rain %g: %s", ErrInvalidArg, in, err.Error())
+	}
+
+	return c.putAccum(ctx, "PUTRAIN", p)
+}
+
+// PutET sets the yearly evapotranspiration accumulation in inches.
+func (c Conn) PutET(in float64) error {
+	return c.putET(c.brokerContext(), in)
+}
+
+// putET sets the yearly evapotranspiration accumulation in inches.
+func (c Conn) putET(ctx context.Context, in float64) error {
+	p, err := data.YearET(in).MarshalText()
+	if err != nil {
+		return fmt.Errorf("%w: yearly ET %g: %s", ErrInvalidArg, in, err.Error())
+	}
+
+	return c.putAccum(ctx, "PUTET", p)
+}
+
+// putAccum runs a command which sets an accumulation to the encoded
+// value.
+func (c Conn) putAccum(ctx context.Context, cmd string, v []byte) (err error) {
+	_, err = c.writeCmd(ctx, []byte(cmd+" "+string(v)+"\n"), []byte{ack}, 0)
+
+	return
+}
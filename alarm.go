@@ -0,0 +1,60 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"context"
+
+	"github.com/ebarkie/weatherlink/data"
+)
+
+// GetAlarms gets the alarm thresholds.
+func (c Conn) GetAlarms() (data.EEAlarms, error) {
+	return c.getAlarms(c.brokerContext())
+}
+
+// getAlarms gets the alarm thresholds from the EEPROM.  The whole image
+// is read because rain thresholds depend on the rain collector size.
+func (c Conn) getAlarms(ctx context.Context) (data.EEAlarms, error) {
+	ee, err := c.readEEPROMImage(ctx)
+
+	return ee.Alarms, err
+}
+
+// SetAlarms sets the alarm thresholds.  Thresholds which are nil are
+// cleared.
+func (c Conn) SetAlarms(a data.EEAlarms) error {
+	return c.setAlarms(c.brokerContext(), a)
+}
+
+// setAlarms writes the alarm thresholds to the EEPROM.
+func (c Conn) setAlarms(ctx context.Context, a data.EEAlarms) (err error) {
+	ee, err := c.readEEPROMImage(ctx)
+	if err != nil {
+		return
+	}
+
+	ee.Alarms = a
+	p, err := ee.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	return c.writeEEPROM(ctx, eeAlarms, p[eeAlarms:eeAlarms+eeAlarmsSize])
+}
+
+// ClearAlarms clears all of the alarm thresholds.
+func (c Conn) ClearAlarms(confirm Confirmation) error {
+	return c.clearAlarms(c.brokerContext(), confirm)
+}
+
+// clearAlarms clears all of the alarm thresholds.
+func (c Conn) clearAlarms(ctx context.Context, confirm Confirmation) error {
+	if err := confirm.check("CLRALM"); err != nil {
+		return err
+	}
+
+	return c.writeCmdDone(ctx, []byte("CLRALM\n"))
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/ebarkie/weatherlink/data"
+)
+
+// GetCal gets the temperature, humidity, and wind direction calibration
+// offsets.
+func (c Conn) GetCal() (data.EECal, error) {
+	return c.getCal(c.brokerContext())
+}
+
+// getCal gets the calibration offsets from the EEPROM.
+func (c Conn) getCal(ctx context.Context) (cal data.EECal, err error) {
+	p, err := c.readEEPROM(ctx, eeCal, eeCalSize)
+	if err != nil {
+		return
+	}
+
+	err = cal.UnmarshalBinary(p)
+
+	return
+}
+
+// SetCal sets the temperature, humidity, and wind direction calibration
+// offsets.  The console display uses them once it receives the next
+// reading from each sensor.
+func (c Conn) SetCal(cal data.EECal) error {
+	return c.setCal(c.brokerContext(), cal)
+}
+
+// setCal writes the calibration offsets to the EEPROM.
+func (c Conn) setCal(ctx context.Context, cal data.EECal) error {
+	p, _ := cal.MarshalBinary()
+
+	return c.writeEEPROM(ctx, eeCal, p)
+}
+
+// ClearCal clears the temperature and humidity calibration offsets.
+func (c Conn) ClearCal(confirm Confirmation) error {
+	return c.clearCal(c.brokerContext(), confirm)
+}
+
+// clearCal clears the temperature and humidity calibration offsets.
+func (c Conn) clearCal(ctx context.Context, confirm Confirmation) error {
+	if err := confirm.check("CLRCAL"); err != nil {
+		return err
+	}
+
+	return c.writeCmdDone(ctx, []byte("CLRCAL\n"))
+}
+
+// GetBarData gets the barometer calibration data.
+func (c Conn) GetBarData() (data.BarData, error) {
+	return c.getBarData(c.brokerContext())
+}
+
+// getBarData gets the barometer calibration data.
+func (c Conn) getBarData(ctx context.Context) (bd data.BarData, err error) {
+	const lines = 9
+
+	_, err = c.writeCmd(ctx, []byte("BARDATA\n"), []byte("\n\rOK\n\r"), 0)
+	if err != nil {
+		return
+	}
+
+	var p []byte
+	p, err = c.readLines(ctx, lines)
+	if err != nil {
+		return
+	}
+
+	err = bd.UnmarshalText(p)
+
+	return
+}
+
+// SetBarCal calibrates the barometer for the station elevation in feet.
+// If bar is a reliable reference reading in inHg the console offsets its
+// own readings to match it, otherwise it should be 0 which also clears
+// any existing offset.
+func (c Conn) SetBarCal(bar float64, elev int) error {
+	return c.setBarCal(c.brokerContext(), bar, elev)
+}
+
+// setBarCal calibrates the barometer.
+func (c Conn) setBarCal(ctx context.Context, bar float64, elev int) (err error) {
+	if (bar != 0 && (bar < 20.0 || bar > 32.5)) || elev < -2000 || elev > 15000 {
+		return fmt.Errorf("%w: barometer %g elevation %d", ErrInvalidArg, bar, elev)
+	}
+
+	cmd := fmt.Sprintf("BAR=%d %d\n", int(math.Round(bar*1000.0)), elev)
+	_, err = c.writeCmd(ctx, []byte(cmd), []byte("\n\rOK\n\r"), 0)
+
+	return
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"context"
+	"fmt"
+)
+
+// Confirmation is the explicit confirmation commands which clear console
+// data require.
+type Confirmation bool
+
+// Confirm confirms a command which clears console data.
+const Confirm Confirmation = true
+
+// check returns ErrNotConfirmed if the command isn't confirmed.
+func (cf Confirmation) check(cmd string) error {
+	if !cf {
+		return fmt.Errorf("%w: %s", ErrNotConfirmed, cmd)
+	}
+
+	return nil
+}
+
+// Period is a period that highs and lows are kept for.
+type Period uint8
+
+// Periods.
+const (
+	Daily Period = iota
+	Monthly
+	Yearly
+)
+
+// Accum is a rain or ET accumulation.
+type Accum uint8
+
+// Accumulations.
+const (
+	DayRain   Accum = 13
+	StormRain Accum = 14
+	MonthRain Accum = 16
+	YearRain  Accum = 17
+	MonthET   Accum = 25
+	DayET     Accum = 26
+	YearET    Accum = 27
+)
+
+// ClearHighs clears the high values for the period.
+func (c Conn) ClearHighs(p Period, confirm Confirmation) error {
+	return c.clearHighs(c.brokerContext(), p, confirm)
+}
+
+// clearHighs clears the high values for the period.
+func (c Conn) clearHighs(ctx context.Context, p Period, confirm Confirmation) error {
+	return c.clearPeriod(ctx, "CLRHIGHS", p, confirm)
+}
+
+// ClearLows clears the low values for the period.
+func (c Conn) ClearLows(p Period, confirm Confirmation) error {
+	return c.clearLows(c.brokerContext(), p, confirm)
+}
+
+// clearLows clears the low values for the period.
+func (c Conn) clearLows(ctx context.Context, p Period, confirm Confirmation) error {
+	return c.clearPeriod(ctx, "CLRLOWS", p, confirm)
+}
+
+// clearPeriod runs a command which clears the highs or lows for the
+// period.
+func (c Conn) clearPeriod(ctx context.Context, cmd string, p Period, confirm Confirmation) (err error) {
+	if err = confirm.check(cmd); err != nil {
+		return
+	}
+	if p > Yearly {
+		return fmt.Errorf("%w: period %d", ErrInvalidArg, p)
+	}
+
+	_, err = c.writeCmd(ctx, []byte(fmt.Sprintf("%s %d\n", cmd, p)), []byte{ack}, 0)
+
+	return
+}
+
+// ClearLog clears the archive memory.
+func (c Conn) ClearLog(confirm Confirmation) error {
+	return c.clearLog(c.brokerContext(), confirm)
+}
+
+// clearLog clears the archive memory.
+func (c Conn) clearLog(ctx context.Context, confirm Confirmation) (err error) {
+	if err = confirm.check("CLRLOG"); err != nil {
+		return
+	}
+
+	_, err = c.writeCmd(ctx, []byte("CLRLOG\n"), []byte{ack}, 0)
+
+	return
+}
+
+// ClearAccum clears a rain or ET accumulation.
+func (c Conn) ClearAccum(a Accum, confirm Confirmation) error {
+	return c.clearAccum(c.brokerContext(), a, confirm)
+}
+
+// clearAccum clears a rain or ET accumulation.
+func (c Conn) clearAccum(ctx context.Context, a Accum, confirm Confirmation) (err error) {
+	if err = confirm.check("CLRVAR"); err != nil {
+		return
+	}
+	switch a {
+	case DayRain, StormRain, MonthRain, YearRain, MonthET, DayET, YearET:
+	default:
+		// The console's behavior is undefined for anything else.
+		return fmt.Errorf("%w: accumulation %d", ErrInvalidArg, a)
+	}
+
+	_, err = c.writeCmd(ctx, []byte(fmt.Sprintf("CLRVAR %d\n", a)), []byte{ack}, 0)
+
+	return
+}
+
+// ClearGraphs clears the graph data.
+func (c Conn) ClearGraphs(confirm Confirmation) error {
+	return c.clearGraphs(c.brokerContext(), confirm)
+}
+
+// clearGraphs clears the graph data.
+func (c Conn) clearGraphs(ctx context.Context, confirm Confirmation) error {
+	if err := confirm.check("CLRGRA"); err != nil {
+		return err
+	}
+
+	return c.writeCmdDone(ctx, []byte("CLRGRA\n"))
+}
@@ -0,0 +1,426 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"context"
+	"time"
+
+	"github.com/ebarkie/weatherlink/data"
+)
+
+// Command is a command which can be queued for the command broker.
+type Command interface {
+	exec(ctx context.Context, c *Conn, ec chan<- interface{}) (Result, error)
+}
+
+// Result is the payload of a command run by Do.  Its type depends on
+// the command:
+//
+// GetAlarms returns a data.EEAlarms, GetBarData returns a data.BarData,
+// GetCal returns a data.EECal, GetConsTime and GetFirmBuildTime return a
+// time.Time, GetDmps returns the time.Time of the last record it read,
+// GetEEPROM returns a data.EEPROM, GetFirmVer returns a string, GetHiLows
+// returns a data.HiLows, GetReceivers returns a data.Receivers, GetRxCheck
+// returns a data.RxStats, DumpArchive returns the int number of records it
+// sent, ReadEEPROM returns a []byte, and ResumeDmps returns a DmpToken.
+// All other commands return nil.
+type Result interface{}
+
+type cmd uint8
+
+// Commands.
+const (
+	GetAlarms cmd = iota
+	GetBarData
+	GetCal
+	GetConsTime
+	GetDmps
+	GetEEPROM
+	GetFirmBuildTime
+	GetFirmVer
+	GetHiLows
+	GetLoops
+	GetReceivers
+	GetRxCheck
+	LampsOff
+	LampsOn
+	NewSetup
+	StartArchiving
+	Stop
+	StopArchiving
+	SyncConsTime
+)
+
+// exec runs the command.
+func (cmd cmd) exec(ctx context.Context, c *Conn, ec chan<- interface{}) (r Result, err error) {
+	switch cmd {
+	case GetAlarms:
+		r, err = c.getAlarms(ctx)
+	case GetBarData:
+		r, err = c.getBarData(ctx)
+	case GetCal:
+		r, err = c.getCal(ctx)
+	case GetConsTime:
+		r, err = c.getConsTime(ctx)
+	case GetDmps:
+		c.LastDmp, err = c.GetDmpsContext(ctx, ec, c.LastDmp)
+		r = c.LastDmp
+	case GetEEPROM:
+		r, err = c.getEEPROM(ctx, ec)
+	case GetFirmBuildTime:
+		r, err = c.getFirmBuildTime(ctx)
+	case GetFirmVer:
+		r, err = c.getFirmVer(ctx)
+	case GetHiLows:
+		r, err = c.getHiLows(ctx, ec)
+	case GetLoops:
+		err = c.GetLoopsContext(ctx, ec)
+	case GetReceivers:
+		var rcv data.Receivers
+		if rcv, err = c.getReceivers(ctx); err == nil {
+			r, err = rcv, emit(ctx, ec, rcv)
+		}
+	case GetRxCheck:
+		var rs data.RxStats
+		if rs, err = c.getRxCheck(ctx); err == nil {
+			r, err = rs, emit(ctx, ec, rs)
+		}
+	case LampsOff:
+		err = c.setLamps(ctx, false)
+	case LampsOn:
+		err = c.setLamps(ctx, true)
+	case NewSetup:
+		err = c.newSetup(ctx)
+	case StartArchiving:
+		err = c.setArchiving(ctx, true)
+	case StopArchiving:
+		err = c.setArchiving(ctx, false)
+	case SyncConsTime:
+		err = c.SyncConsTimeContext(ctx)
+	default:
+		// Should never happen unless new commands are added and
+		// not defined here.
+		Error.Printf("Unhandled command: %d", cmd)
+		err = ErrCmdFailed
+	}
+
+	return
+}
+
+// ClearAccum is a command which clears a rain or ET accumulation.
+type ClearAccum struct {
+	Accum   Accum
+	Confirm Confirmation
+}
+
+// exec runs the command.
+func (cmd ClearAccum) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.clearAccum(ctx, cmd.Accum, cmd.Confirm)
+}
+
+// ClearAlarms is a command which clears all of the alarm thresholds.
+type ClearAlarms Confirmation
+
+// exec runs the command.
+func (cmd ClearAlarms) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.clearAlarms(ctx, Confirmation(cmd))
+}
+
+// ClearCal is a command which clears the temperature and humidity
+// calibration offsets.
+type ClearCal Confirmation
+
+// exec runs the command.
+func (cmd ClearCal) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.clearCal(ctx, Confirmation(cmd))
+}
+
+// ClearGraphs is a command which clears the graph data.
+type ClearGraphs Confirmation
+
+// exec runs the command.
+func (cmd ClearGraphs) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.clearGraphs(ctx, Confirmation(cmd))
+}
+
+// ClearHighs is a command which clears the high values for a period.
+type ClearHighs struct {
+	Period  Period
+	Confirm Confirmation
+}
+
+// exec runs the command.
+func (cmd ClearHighs) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.clearHighs(ctx, cmd.Period, cmd.Confirm)
+}
+
+// ClearLog is a command which clears the archive memory.
+type ClearLog Confirmation
+
+// exec runs the command.
+func (cmd ClearLog) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.clearLog(ctx, Confirmation(cmd))
+}
+
+// ClearLows is a command which clears the low values for a period.
+type ClearLows struct {
+	Period  Period
+	Confirm Confirmation
+}
+
+// exec runs the command.
+func (cmd ClearLows) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.clearLows(ctx, cmd.Period, cmd.Confirm)
+}
+
+// DumpArchive is a command which downloads every page of the archive
+// memory.
+type DumpArchive struct {
+	Progress ProgressFunc // Called after each page if not nil
+}
+
+// exec runs the command.
+func (cmd DumpArchive) exec(ctx context.Context, c *Conn, ec chan<- interface{}) (Result, error) {
+	return c.DumpArchiveContext(ctx, ec, cmd.Progress)
+}
+
+// PutET is a command which sets the yearly evapotranspiration
+// accumulation in inches.
+type PutET float64
+
+// exec runs the command.
+func (cmd PutET) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.putET(ctx, float64(cmd))
+}
+
+// PutRain is a command which sets the yearly rain accumulation in inches.
+type PutRain float64
+
+// exec runs the command.
+func (cmd PutRain) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.putRain(ctx, float64(cmd))
+}
+
+// ReadEEPROM is a command which reads a range of the EEPROM.
+type ReadEEPROM struct {
+	Addr int // Start address
+	Len  int // Number of bytes
+}
+
+// exec runs the command.
+func (cmd ReadEEPROM) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return c.readEEPROM(ctx, cmd.Addr, cmd.Len)
+}
+
+// ResumeDmps is a command which resumes an interrupted archive download.
+type ResumeDmps struct {
+	Token    DmpToken     // Token of the interrupted download
+	Progress ProgressFunc // Called after each page if not nil
+}
+
+// exec runs the command.
+func (cmd ResumeDmps) exec(ctx context.Context, c *Conn, ec chan<- interface{}) (Result, error) {
+	return c.ResumeDmpsContext(ctx, ec, cmd.Token, cmd.Progress)
+}
+
+// SetAlarms is a command which sets the alarm thresholds.
+type SetAlarms data.EEAlarms
+
+// exec runs the command.
+func (cmd SetAlarms) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setAlarms(ctx, data.EEAlarms(cmd))
+}
+
+// SetArchivePeriod is a command which sets the console archive period in
+// minutes.
+type SetArchivePeriod int
+
+// exec runs the command.
+func (cmd SetArchivePeriod) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setArchivePeriod(ctx, int(cmd))
+}
+
+// SetBarCal is a command which calibrates the barometer.
+type SetBarCal struct {
+	Bar  float64 // Reference reading in inHg, 0 if there isn't one
+	Elev int     // Station elevation in feet
+}
+
+// exec runs the command.
+func (cmd SetBarCal) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setBarCal(ctx, cmd.Bar, cmd.Elev)
+}
+
+// SetBaud is a command which sets the console baud rate.
+type SetBaud int
+
+// exec runs the command.
+func (cmd SetBaud) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setBaud(ctx, int(cmd))
+}
+
+// SetCal is a command which sets the calibration offsets.
+type SetCal data.EECal
+
+// exec runs the command.
+func (cmd SetCal) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setCal(ctx, data.EECal(cmd))
+}
+
+// SetConsTime is a command which sets the console time.
+type SetConsTime time.Time
+
+// exec runs the command.
+func (cmd SetConsTime) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setConsTime(ctx, time.Time(cmd))
+}
+
+// SetElevation is a command which sets the station elevation in feet.
+type SetElevation int
+
+// exec runs the command.
+func (cmd SetElevation) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setElevation(ctx, int(cmd))
+}
+
+// SetLocation is a command which sets the station latitude and longitude.
+type SetLocation struct {
+	Lat float64 // Latitude in degrees, negative is south
+	Lon float64 // Longitude in degrees, negative is west
+}
+
+// exec runs the command.
+func (cmd SetLocation) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setLocation(ctx, cmd.Lat, cmd.Lon)
+}
+
+// SetStations is a command which sets the transmitter station list.
+type SetStations struct {
+	Stations   [8]data.EEStation // Stations by ID
+	Retransmit int               // ID to retransmit as, 0 if off
+}
+
+// exec runs the command.
+func (cmd SetStations) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setStations(ctx, cmd.Stations, cmd.Retransmit)
+}
+
+// SetTimeZone is a command which sets the console time zone and daylight
+// savings settings.
+type SetTimeZone data.EETimeZone
+
+// exec runs the command.
+func (cmd SetTimeZone) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.setTimeZone(ctx, data.EETimeZone(cmd))
+}
+
+// WriteEEPROM is a command which writes a range of the EEPROM.
+type WriteEEPROM struct {
+	Addr int    // Start address
+	Data []byte // Bytes to write
+}
+
+// exec runs the command.
+func (cmd WriteEEPROM) exec(ctx context.Context, c *Conn, _ chan<- interface{}) (Result, error) {
+	return nil, c.writeEEPROM(ctx, cmd.Addr, cmd.Data)
+}
+
+// request is a command queued by Do and where to send its outcome.
+type request struct {
+	ctx  context.Context
+	cmd  Command
+	resp chan response
+}
+
+// response is the outcome of a request.
+type response struct {
+	r   Result
+	err error
+}
+
+// Do queues a command for the command broker, waits for it to run,
+// and returns its result.  It returns early if the context is done
+// before the command completes and with ErrStopped if the broker isn't
+// running.
+func (c Conn) Do(ctx context.Context, cmd Command) (Result, error) {
+	req := request{ctx: ctx, cmd: cmd, resp: make(chan response, 1)}
+	stopped := c.brokerStopped()
+
+	select {
+	case c.req <- req:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-stopped:
+		return nil, ErrStopped
+	}
+
+	select {
+	case resp := <-req.resp:
+		return resp.r, resp.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-stopped:
+		// The broker responds before it stops if it got the request.
+		select {
+		case resp := <-req.resp:
+			return resp.r, resp.err
+		default:
+			return nil, ErrStopped
+		}
+	}
+}
+
+// brokerStopped returns a channel which is closed when the command broker
+// isn't running.
+func (c Conn) brokerStopped() <-chan struct{} {
+	if c.stopped == nil {
+		stopped := make(chan struct{})
+		close(stopped)
+		return stopped
+	}
+
+	return *c.stopped.Load()
+}
+
+// run runs a request using a context which is done when either the
+// broker or the request context is done.  The returned error is the one
+// the broker should act on.
+func (c *Conn) run(ctx context.Context, req request, ec chan<- interface{}) error {
+	if err := req.ctx.Err(); err != nil {
+		// Nobody is waiting for the result anymore.
+		req.resp <- response{err: err}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		select {
+		case <-req.ctx.Done():
+			cancel()
+		case <-release:
+		}
+	}()
+
+	r, err := req.cmd.exec(ctx, c, ec)
+	req.resp <- response{r: r, err: err}
+
+	return err
+}
+
+// drain fails any requests still queued after the broker stops.
+func (c Conn) drain() {
+	for {
+		select {
+		case req := <-c.req:
+			req.resp <- response{err: ErrStopped}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Wlsim emulates a WeatherLink IP so tools can be tested against a
+// console over the network without hardware.  The console is either
+// simulated or a recorded session is played back.
+//
+// Usage:
+//
+//	wlsim [-addr :22222] [-replay session.log] [fault flags]
+//
+// Only one client is served at a time, like a WeatherLink IP.  A
+// recorded session continues across reconnects, so hard-resets play back,
+// and starts over once it's been played back.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/ebarkie/weatherlink/internal/device"
+)
+
+// dev is the device a client is connected to.
+type dev interface {
+	io.ReadWriteCloser
+	Dial(addr string) error
+	Flush() error
+}
+
+// pollTime is how long to wait before reading the device again when it
+// has nothing to send.
+const pollTime = 10 * time.Millisecond
+
+func main() {
+	var s device.Sim
+	addr := flag.String("addr", ":22222", "listen address")
+	replay := flag.String("replay", "", "recorded session to play back instead of simulating")
+	flag.DurationVar(&s.LoopDelay, "loopdelay", 2*time.Second, "delay between loop packets")
+	flag.DurationVar(&s.Timeout, "timeout", 6*time.Second, "how long stalled reads take")
+	flag.Float64Var(&s.Faults.BadAck, "badack", 0, "rate at which ACKs are garbage")
+	flag.Float64Var(&s.Faults.CRC, "crc", 0, "rate at which packet CRCs are corrupted")
+	flag.Float64Var(&s.Faults.Drop, "drop", 0, "rate at which a byte is dropped from responses")
+	flag.Float64Var(&s.Faults.Stall, "stall", 0, "rate at which reads stall")
+	flag.Float64Var(&s.Faults.Wakeup, "wakeup", 0, "rate at which spurious wakeup responses are sent")
+	flag.Parse()
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Listening on %s", l.Addr())
+
+	next := func() (dev, error) { return &s, nil }
+	if *replay != "" {
+		next = replayer(*replay)
+	}
+	log.Fatal(accept(l, next))
+}
+
+// accept serves clients one at a time until the listener fails.  next
+// returns the device for each client.
+func accept(l net.Listener, next func() (dev, error)) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		d, err := next()
+		if err != nil {
+			log.Printf("Device open error: %s", err.Error())
+			conn.Close()
+			continue
+		}
+
+		serve(conn, d)
+	}
+}
+
+// replayer returns a function which returns the recorded session for each
+// client.  It's only opened again once it's been played back.
+func replayer(name string) func() (dev, error) {
+	var rp *device.Replay
+	return func() (dev, error) {
+		if rp == nil || rp.Done() {
+			var err error
+			if rp, err = openReplay(name); err != nil {
+				return nil, err
+			}
+		}
+
+		return rp, nil
+	}
+}
+
+// openReplay opens a recorded session so it's played back from the
+// start.
+func openReplay(name string) (*device.Replay, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return device.NewReplay(f)
+}
+
+// isText returns true if b is made up of whole text commands, each ending
+// with a line feed.
+func isText(b []byte) bool {
+	if len(b) < 1 || b[len(b)-1] != '\n' {
+		return false
+	}
+	for _, c := range b {
+		if (c < ' ' || c > '~') && c != '\n' && c != '\r' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// commands splits what was read from a client into the commands it wrote.
+// Text commands which arrive together are split after each line feed.
+// Anything else, like a binary payload, is passed along as it is.
+func commands(b []byte) [][]byte {
+	if !isText(b) {
+		return [][]byte{b}
+	}
+
+	var cmds [][]byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		cmds = append(cmds, b[:i])
+		b = b[i:]
+	}
+
+	return cmds
+}
+
+// serve relays between a client and the device until either side is
+// done.
+//
+// A client flushes by discarding what it has received, which can't be
+// seen over TCP.  A simulated console's responses were already relayed so
+// there's nothing left for it to flush, but a recorded session has to
+// play back its flushes.  They're forwarded before each command and when
+// the client disconnects, which is where a client can flush.
+func serve(conn net.Conn, d dev) {
+	flush := func() {}
+	if _, ok := d.(*device.Replay); ok {
+		flush = func() { d.Flush() }
+	}
+
+	log.Printf("Client %s connected", conn.RemoteAddr())
+	defer log.Printf("Client %s disconnected", conn.RemoteAddr())
+
+	if err := d.Dial(""); err != nil {
+		log.Printf("Device dial error: %s", err.Error())
+		conn.Close()
+		return
+	}
+
+	// Client to device.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b := make([]byte, 512)
+		for {
+			n, err := conn.Read(b)
+			if err != nil {
+				return
+			}
+			for _, cmd := range commands(b[:n]) {
+				flush()
+				if _, err := d.Write(cmd); err != nil {
+					log.Printf("Device write error: %s", err.Error())
+					return
+				}
+			}
+		}
+	}()
+
+	// Device to client.  The buffer is large enough for the biggest
+	// response, an EEPROM dump, so recorded reads are never split.
+	b := make([]byte, 8192)
+relay:
+	for {
+		select {
+		case <-done:
+			break relay
+		default:
+		}
+
+		n, err := d.Read(b)
+		if n > 0 {
+			if _, err := conn.Write(b[:n]); err != nil {
+				break relay
+			}
+		}
+		if err == io.EOF {
+			log.Println("Session played back")
+			break relay
+		} else if n < 1 {
+			time.Sleep(pollTime)
+		}
+	}
+
+	conn.Close()
+	<-done
+	flush()
+	d.Close()
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ebarkie/weatherlink"
+	"github.com/ebarkie/weatherlink/internal/device"
+	"github.com/stretchr/testify/assert"
+)
+
+// listen serves clients on a local address with the devices returned by
+// next and returns the address.
+func listen(t *testing.T, next func() (dev, error)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go accept(l, next)
+
+	return l.Addr().String()
+}
+
+func TestCommands(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		b    string
+		cmds []string
+	}{
+		{"TEST\n", []string{"TEST\n"}},
+		{"\nLOOP 1\n", []string{"\n", "LOOP 1\n"}},
+		{"EEBRD 2D 01\nTEST\n", []string{"EEBRD 2D 01\n", "TEST\n"}},
+		{"\x06", []string{"\x06"}},
+		{"\x0c\x1e\x0a\x14\x06\x74\x9b\x0a", []string{"\x0c\x1e\x0a\x14\x06\x74\x9b\x0a"}},
+		{"TES", []string{"TES"}},
+	}
+	for _, test := range tests {
+		var cmds []string
+		for _, cmd := range commands([]byte(test.b)) {
+			cmds = append(cmds, string(cmd))
+		}
+		a.Equal(test.cmds, cmds, "%q", test.b)
+	}
+}
+
+func TestServeSim(t *testing.T) {
+	a := assert.New(t)
+
+	s := &device.Sim{Clock: func() time.Time {
+		return time.Date(2016, time.June, 20, 12, 0, 0, 0, time.UTC)
+	}}
+	addr := listen(t, func() (dev, error) { return s, nil })
+
+	// Commands which arrive together are each answered.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write([]byte("\nTEST\n"))
+	a.Nil(err)
+	b := make([]byte, 10)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(conn, b)
+	a.Nil(err)
+	a.Equal("\n\r\n\rTEST\n\r", string(b))
+	conn.Close()
+
+	// The client can reconnect and download the archive.
+	c, err := weatherlink.Dial("tcp://" + addr + "?timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ec := make(chan interface{}, 5*512)
+	_, err = c.GetDmps(ec, time.Time{})
+	a.Nil(err)
+	a.Equal(24*12, len(ec))
+}
+
+func TestServeStall(t *testing.T) {
+	a := assert.New(t)
+
+	// Every command stalls once but is still answered, even though the
+	// device is polled while waiting.
+	s := &device.Sim{Timeout: 50 * time.Millisecond, Faults: device.Faults{Stall: 1}}
+	conn, err := net.Dial("tcp", listen(t, func() (dev, error) { return s, nil }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err = conn.Write([]byte("TEST\n"))
+		a.Nil(err)
+		b := make([]byte, 8)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = io.ReadFull(conn, b)
+		a.Nil(err)
+		a.Equal("\n\rTEST\n\r", string(b))
+	}
+}
+
+func TestServeReplay(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(f time.Duration) { weatherlink.ConsTimeSyncFreq = f }(weatherlink.ConsTimeSyncFreq)
+	weatherlink.ConsTimeSyncFreq = 0
+
+	// The reset session flushes, closes, and reconnects so it's played
+	// back across two clients.
+	var rp *device.Replay
+	next := replayer("../../testdata/reset.log")
+	addr := listen(t, func() (dev, error) {
+		d, err := next()
+		rp, _ = d.(*device.Replay)
+		return d, err
+	})
+
+	c, err := weatherlink.Dial("tcp://" + addr + "?timeout=200ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.CmdRetry = weatherlink.RetryPolicy{MaxAttempts: 1}
+	c.ResetRetry = weatherlink.RetryPolicy{MaxAttempts: 1}
+
+	n := 0
+	ec := c.Start(func(c *weatherlink.Conn, _ chan<- interface{}) error {
+		n++
+		if n == 1 {
+			return weatherlink.ErrCmdFailed
+		}
+		c.Q <- weatherlink.Stop
+		return nil
+	})
+	for range ec {
+	}
+	a.Equal(weatherlink.Connected, c.State())
+	a.True(rp.Done(), "Session not fully played back")
+}
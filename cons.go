@@ -5,37 +5,46 @@
 package weatherlink
 
 import (
+	"context"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/ebarkie/weatherlink/data"
 )
 
-// GetConsTime gets the console time.
+// GetConsTime gets the console time.  It's in the console time zone.
 func (c Conn) GetConsTime() (time.Time, error) {
-	p, err := c.writeCmd([]byte("GETTIME\n"), []byte{ack}, 8)
+	return c.getConsTime(c.brokerContext())
+}
+
+// getConsTime gets the console time.
+func (c Conn) getConsTime(ctx context.Context) (time.Time, error) {
+	p, err := c.writeCmd(ctx, []byte("GETTIME\n"), []byte{ack}, 8)
 	if err != nil {
 		return time.Time{}, err
 	}
 
 	var ct data.ConsTime
-	err = ct.UnmarshalBinary(p)
+	err = ct.UnmarshalBinaryIn(p, c.ConsLocation())
 	return time.Time(ct), err
 }
 
-// setConsTime sets the console time.
-func (c Conn) setConsTime(t time.Time) (err error) {
-	_, err = c.writeCmd([]byte("SETTIME\n"), []byte{ack}, 0)
+// setConsTime sets the console time.  The console only keeps local time
+// so it's set to t in the console time zone.
+func (c Conn) setConsTime(ctx context.Context, t time.Time) (err error) {
+	_, err = c.writeCmd(ctx, []byte("SETTIME\n"), []byte{ack}, 0)
 	if err != nil {
 		return
 	}
 
 	var p []byte
-	p, err = data.ConsTime(t).MarshalBinary()
+	p, err = data.ConsTime(t.In(c.ConsLocation())).MarshalBinary()
 	if err != nil {
 		return
 	}
 
-	_, err = c.writeCmd(p, []byte{ack}, 0)
+	_, err = c.writeCmd(ctx, p, []byte{ack}, 0)
 
 	return
 }
@@ -43,9 +52,15 @@ func (c Conn) setConsTime(t time.Time) (err error) {
 // SyncConsTime synchronizes the console time with the local
 // system time if the offset exceeds 10 seconds.
 func (c Conn) SyncConsTime() error {
+	return c.SyncConsTimeContext(c.brokerContext())
+}
+
+// SyncConsTimeContext is like SyncConsTime but aborts when the context
+// is done.
+func (c Conn) SyncConsTimeContext(ctx context.Context) error {
 	const maxOffset = 10 * time.Second
 
-	t, err := c.GetConsTime()
+	t, err := c.getConsTime(ctx)
 	if err != nil {
 		return err
 	}
@@ -58,7 +73,7 @@ func (c Conn) SyncConsTime() error {
 
 	if offset > maxOffset {
 		Info.Printf("Console time is off by %s, syncing", offset)
-		err = c.setConsTime(time.Now())
+		err = c.setConsTime(ctx, time.Now())
 		if err != nil {
 			Error.Println(err.Error())
 		}
@@ -68,12 +83,81 @@ func (c Conn) SyncConsTime() error {
 }
 
 // SetLamps sets the console lamps state.
-func (c Conn) SetLamps(on bool) (err error) {
+func (c Conn) SetLamps(on bool) error {
+	return c.setLamps(c.brokerContext(), on)
+}
+
+// setLamps sets the console lamps state.
+func (c Conn) setLamps(ctx context.Context, on bool) (err error) {
 	state := "0"
 	if on {
 		state = "1"
 	}
-	_, err = c.writeCmd([]byte("LAMPS "+state+"\n"), []byte("\n\rOK\n\r"), 0)
+	_, err = c.writeCmd(ctx, []byte("LAMPS "+state+"\n"), []byte("\n\rOK\n\r"), 0)
+
+	return
+}
+
+// baudRates are the baud rates the console supports.
+var baudRates = []int{1200, 2400, 4800, 9600, 14400, 19200}
+
+// validBaud returns true if rate is a baud rate the console supports.
+func validBaud(rate int) bool {
+	for _, r := range baudRates {
+		if r == rate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetBaud sets the console baud rate and switches the device to it.  Only
+// serial devices support this.
+func (c Conn) SetBaud(rate int) error {
+	return c.setBaud(c.brokerContext(), rate)
+}
+
+// setBaud sets the console baud rate and switches the device to it.
+func (c Conn) setBaud(ctx context.Context, rate int) (err error) {
+	// Time to wait for the command to be sent before switching rates.
+	const sendTime = 100 * time.Millisecond
+
+	if !validBaud(rate) {
+		return fmt.Errorf("%w: baud rate %d", ErrInvalidArg, rate)
+	}
+
+	d, ok := c.d.(interface {
+		GetBaud() int
+		SetBaud(int) error
+	})
+	if !ok {
+		return ErrNotSupported
+	}
+	old := d.GetBaud()
+	if rate == old {
+		return nil
+	}
+
+	// The console answers at the new rate so switch the device as soon as
+	// the command is sent, discard the answer, and test to verify.
+	Info.Printf("Changing baud rate from %d to %d", old, rate)
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	c.d.Write([]byte("BAUD " + strconv.Itoa(rate) + "\n"))
+	time.Sleep(sendTime)
+	if err = d.SetBaud(rate); err != nil {
+		return
+	}
+	c.d.Flush()
+
+	err = c.test(ctx)
+	if err != nil {
+		Error.Printf("Console did not respond at %d baud, reverting to %d", rate, old)
+		d.SetBaud(old)
+		c.d.Flush()
+	}
 
 	return
 }
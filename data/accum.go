@@ -0,0 +1,78 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package data
+
+// Packet coding logic for the PUTRAIN and PUTET commands.
+//
+// Refer to Vantage Pro™, Vantage Pro2™ and Vantage Vue™ Serial
+// Communication Reference Manual, section VIII. Command Summary,
+// subsection 2. Current Data Commands.
+
+import (
+	"math"
+	"strconv"
+)
+
+// maxAccum is the largest accumulation in hundredths of an inch, which
+// is the largest loop packets can report.
+const maxAccum = 0x7fff
+
+// YearRain is the yearly rain accumulation in inches.  Like loop packets
+// it assumes a 0.01 inch rain collector.
+type YearRain float64
+
+// MarshalText encodes the yearly rain into the argument of a PUTRAIN
+// command, which is in rain clicks.
+func (yr YearRain) MarshalText() ([]byte, error) {
+	return marshalAccum(float64(yr))
+}
+
+// UnmarshalText decodes the argument of a PUTRAIN command into the
+// YearRain.
+func (yr *YearRain) UnmarshalText(p []byte) error {
+	v, err := unmarshalAccum(p)
+	*yr = YearRain(v)
+
+	return err
+}
+
+// YearET is the yearly evapotranspiration accumulation in inches.
+type YearET float64
+
+// MarshalText encodes the yearly ET into the argument of a PUTET command,
+// which is in hundredths of an inch.
+func (ye YearET) MarshalText() ([]byte, error) {
+	return marshalAccum(float64(ye))
+}
+
+// UnmarshalText decodes the argument of a PUTET command into the YearET.
+func (ye *YearET) UnmarshalText(p []byte) error {
+	v, err := unmarshalAccum(p)
+	*ye = YearET(v)
+
+	return err
+}
+
+// marshalAccum encodes an accumulation in inches as hundredths of an
+// inch.
+func marshalAccum(in float64) ([]byte, error) {
+	n := int(math.Round(in * 100.0))
+	if n < 0 || n > maxAccum {
+		return []byte("0"), ErrBadAccum
+	}
+
+	return []byte(strconv.Itoa(n)), nil
+}
+
+// unmarshalAccum decodes hundredths of an inch into an accumulation in
+// inches.
+func unmarshalAccum(p []byte) (float64, error) {
+	n, err := strconv.Atoi(string(p))
+	if err != nil || n < 0 || n > maxAccum {
+		return 0, ErrBadAccum
+	}
+
+	return float64(n) / 100.0, nil
+}
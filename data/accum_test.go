@@ -0,0 +1,54 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestYearRainMarshalText(t *testing.T) {
+	a := assert.New(t)
+
+	p, err := YearRain(24.83).MarshalText()
+	a.Nil(err, "MarshalText YearRain")
+	a.Equal([]byte("2483"), p, "Yearly rain")
+
+	_, err = YearRain(-1).MarshalText()
+	a.Equal(ErrBadAccum, err, "Negative")
+	_, err = YearRain(400).MarshalText()
+	a.Equal(ErrBadAccum, err, "Too large")
+}
+
+func TestYearRainUnmarshalText(t *testing.T) {
+	a := assert.New(t)
+
+	var yr YearRain
+	err := yr.UnmarshalText([]byte("2483"))
+	a.Nil(err, "UnmarshalText YearRain")
+	a.Equal(YearRain(24.83), yr, "Yearly rain")
+
+	a.Equal(ErrBadAccum, yr.UnmarshalText([]byte("x")), "Not a number")
+}
+
+func TestYearETMarshalText(t *testing.T) {
+	a := assert.New(t)
+
+	p, err := YearET(24.83).MarshalText()
+	a.Nil(err, "MarshalText YearET")
+	a.Equal([]byte("2483"), p, "Yearly ET")
+}
+
+func TestYearETUnmarshalText(t *testing.T) {
+	a := assert.New(t)
+
+	var ye YearET
+	err := ye.UnmarshalText([]byte("2483"))
+	a.Nil(err, "UnmarshalText YearET")
+	a.Equal(YearET(24.83), ye, "Yearly ET")
+
+	a.Equal(ErrBadAccum, ye.UnmarshalText([]byte("-5")), "Negative")
+}
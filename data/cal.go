@@ -0,0 +1,98 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package data
+
+// Packet coding logic for the BARDATA command.
+//
+// Refer to Vantage Pro™, Vantage Pro2™ and Vantage Vue™ Serial
+// Communication Reference Manual, section VIII. Command Summary,
+// subsection 5. Calibration Commands.
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// BarData is the barometer calibration data.
+type BarData struct {
+	Bar         float64 `json:"barometer"`            // Most recent reading in inHg
+	BarCal      float64 `json:"barometerCalibration"` // Offset set with BAR= in inHg
+	DewPoint    int     `json:"dewPoint"`             // Dew point when the reading was taken
+	Elev        int     `json:"elevation"`
+	Gain        int     `json:"gain"`               // Factory calibration
+	HumCorr     int     `json:"humidityCorrection"` // Humidity correction factor
+	Offset      int     `json:"offset"`             // Factory calibration
+	Ratio       float64 `json:"correctionRatio"`    // Multiplied by the raw reading
+	VirtualTemp int     `json:"virtualTemperature"` // 12 hour average temperature
+}
+
+// barDataLines is the number of lines in a BARDATA response.
+const barDataLines = 9
+
+// MarshalText encodes the barometer calibration data into the lines of a
+// BARDATA response.
+func (bd BarData) MarshalText() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "BAR %d\n\r", int(math.Round(bd.Bar*1000.0)))
+	fmt.Fprintf(&b, "ELEVATION %d\n\r", bd.Elev)
+	fmt.Fprintf(&b, "DEW POINT %d\n\r", bd.DewPoint)
+	fmt.Fprintf(&b, "VIRTUAL TEMP %d\n\r", bd.VirtualTemp)
+	fmt.Fprintf(&b, "C %d\n\r", bd.HumCorr)
+	fmt.Fprintf(&b, "R %d\n\r", int(math.Round(bd.Ratio*1000.0)))
+	fmt.Fprintf(&b, "BARCAL %d\n\r", int(math.Round(bd.BarCal*1000.0)))
+	fmt.Fprintf(&b, "GAIN %d\n\r", bd.Gain)
+	fmt.Fprintf(&b, "OFFSET %d\n\r", bd.Offset)
+
+	return b.Bytes(), nil
+}
+
+// UnmarshalText decodes the lines of a BARDATA response into the BarData
+// struct.
+func (bd *BarData) UnmarshalText(p []byte) error {
+	lines := strings.Split(strings.TrimRight(string(p), "\n\r"), "\n\r")
+	if len(lines) != barDataLines {
+		return ErrNotBarData
+	}
+
+	for _, line := range lines {
+		// The name can have spaces so the value is after the last one.
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return ErrNotBarData
+		}
+		v, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return ErrNotBarData
+		}
+
+		switch line[:i] {
+		case "BAR":
+			bd.Bar = float64(v) / 1000.0
+		case "ELEVATION":
+			bd.Elev = v
+		case "DEW POINT":
+			bd.DewPoint = v
+		case "VIRTUAL TEMP":
+			bd.VirtualTemp = v
+		case "C":
+			bd.HumCorr = v
+		case "R":
+			bd.Ratio = float64(v) / 1000.0
+		case "BARCAL":
+			bd.BarCal = float64(v) / 1000.0
+		case "GAIN":
+			bd.Gain = v
+		case "OFFSET":
+			bd.Offset = v
+		default:
+			return ErrNotBarData
+		}
+	}
+
+	return nil
+}
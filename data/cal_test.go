@@ -0,0 +1,61 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// barData is an example BARDATA response.
+var barData = []byte("BAR 29775\n\r" +
+	"ELEVATION 27\n\r" +
+	"DEW POINT 56\n\r" +
+	"VIRTUAL TEMP 63\n\r" +
+	"C 29\n\r" +
+	"R 1001\n\r" +
+	"BARCAL 0\n\r" +
+	"GAIN 1533\n\r" +
+	"OFFSET 18110\n\r")
+
+func TestBarDataMarshalText(t *testing.T) {
+	a := assert.New(t)
+
+	bd := BarData{
+		Bar:         29.775,
+		DewPoint:    56,
+		Elev:        27,
+		Gain:        1533,
+		HumCorr:     29,
+		Offset:      18110,
+		Ratio:       1.001,
+		VirtualTemp: 63,
+	}
+	p, err := bd.MarshalText()
+	a.Nil(err, "MarshalText BarData")
+
+	a.Equal(barData, p, "Barometer calibration data")
+}
+
+func TestBarDataUnmarshalText(t *testing.T) {
+	a := assert.New(t)
+
+	var bd BarData
+	err := bd.UnmarshalText(barData)
+	a.Nil(err, "UnmarshalText BarData")
+
+	a.Equal(29.775, bd.Bar, "Barometer")
+	a.Equal(27, bd.Elev, "Elevation")
+	a.Equal(56, bd.DewPoint, "Dew point")
+	a.Equal(63, bd.VirtualTemp, "Virtual temperature")
+	a.Equal(29, bd.HumCorr, "Humidity correction factor")
+	a.Equal(1.001, bd.Ratio, "Correction ratio")
+	a.Equal(0.0, bd.BarCal, "Barometer calibration")
+	a.Equal(1533, bd.Gain, "Gain")
+	a.Equal(18110, bd.Offset, "Offset")
+
+	a.Equal(ErrNotBarData, bd.UnmarshalText(barData[:20]), "Truncated")
+}
@@ -20,7 +20,8 @@ import (
 type ConsTime time.Time
 
 // MarshalBinary encodes the console time into an 8-byte packet suitable
-// for the SETTIME command.
+// for the SETTIME command.  The time is encoded as it reads in its own
+// location so it should be in the console time zone.
 func (ct ConsTime) MarshalBinary() (p []byte, err error) {
 	p = make([]byte, 8)
 	packet.SetDateTime48(&p, 0, time.Time(ct))
@@ -30,13 +31,19 @@ func (ct ConsTime) MarshalBinary() (p []byte, err error) {
 }
 
 // UnmarshalBinary decodes an 8-byte console time response packet into
-// the ConsTime struct.
+// the ConsTime struct.  The console time is taken to be local time.
 func (ct *ConsTime) UnmarshalBinary(p []byte) error {
+	return ct.UnmarshalBinaryIn(p, time.Local)
+}
+
+// UnmarshalBinaryIn is like UnmarshalBinary but the console time is in
+// the given location, which is usually the console time zone.
+func (ct *ConsTime) UnmarshalBinaryIn(p []byte, loc *time.Location) error {
 	if packet.Crc(p) != 0 {
 		return ErrBadCRC
 	}
 
-	*ct = ConsTime(packet.GetDateTime48(p, 0))
+	*ct = ConsTime(packet.GetDateTime48In(p, 0, loc))
 
 	return nil
 }
@@ -10,12 +10,21 @@ import "errors"
 
 // Errors.
 var (
-	ErrNotArcB     = errors.New("not a revision B archive record")
-	ErrBadCRC      = errors.New("CRC check failed")
-	ErrBadFirmVer  = errors.New("firmware version is not valid")
-	ErrBadLocation = errors.New("location is inconsistent")
-	ErrNotDmp      = errors.New("not a download memory page")
-	ErrNotDmpMeta  = errors.New("not a download memory page metadata packet")
-	ErrNotLoop     = errors.New("not a loop packet")
-	ErrUnknownLoop = errors.New("unknown loop packet type")
+	ErrNotArcB        = errors.New("not a revision B archive record")
+	ErrBadAccum       = errors.New("accumulation is out of range")
+	ErrBadCRC         = errors.New("CRC check failed")
+	ErrBadFirmVer     = errors.New("firmware version is not valid")
+	ErrBadLocation    = errors.New("location is inconsistent")
+	ErrBadTimeZone    = errors.New("time zone is not valid")
+	ErrNotBarData     = errors.New("not barometer calibration data")
+	ErrNotCal         = errors.New("not a calibration region")
+	ErrNotDmp         = errors.New("not a download memory page")
+	ErrNotDmpMeta     = errors.New("not a download memory page metadata packet")
+	ErrNotEEPROM      = errors.New("not an EEPROM image")
+	ErrNotLoop        = errors.New("not a loop packet")
+	ErrNotReceivers   = errors.New("not a receivers bitmap")
+	ErrNotRxCheck     = errors.New("not reception diagnostics")
+	ErrUnknownLoop    = errors.New("unknown loop packet type")
+	ErrUnknownStation = errors.New("unknown station type or repeater")
+	ErrUnknownUnit    = errors.New("unknown unit or size")
 )
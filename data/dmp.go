@@ -51,8 +51,15 @@ type Archive struct {
 	WindSpeedHi    int       `json:"windSpeedHigh"`
 }
 
-// UnmarshalBinary decodes a 52-byte revision B archive record.
+// UnmarshalBinary decodes a 52-byte revision B archive record.  The
+// record time is taken to be local time.
 func (a *Archive) UnmarshalBinary(p []byte) error {
+	return a.UnmarshalBinaryIn(p, time.Local)
+}
+
+// UnmarshalBinaryIn is like UnmarshalBinary but the record time is in the
+// given location, which is usually the console time zone.
+func (a *Archive) UnmarshalBinaryIn(p []byte, loc *time.Location) error {
 	if getArcType(p) != "b" {
 		return ErrNotArcB
 	}
@@ -99,7 +106,7 @@ func (a *Archive) UnmarshalBinary(p []byte) error {
 	}
 	a.SolarRad = packet.GetUInt16(p, 16)
 	a.SolarRadHi = packet.GetUInt16(p, 30)
-	a.Timestamp = packet.GetDateTime32(p, 0)
+	a.Timestamp = packet.GetDateTime32In(p, 0, loc)
 	a.UVIndexAvg = packet.GetUVIndex(p, 28)
 	a.UVIndexHi = packet.GetUVIndex(p, 32)
 	a.WindDirHi = packet.GetWindDir(p, 26)
@@ -111,13 +118,106 @@ func (a *Archive) UnmarshalBinary(p []byte) error {
 	return nil
 }
 
+// MarshalBinary encodes the data from the Archive struct into a 52-byte
+// revision B archive record.  An Archive with a zero Timestamp is encoded
+// as an unwritten record.  The Timestamp is encoded as it reads in its
+// own location so it should be in the console time zone.
+func (a Archive) MarshalBinary() (p []byte, err error) {
+	p = make([]byte, 52)
+	for i := range p {
+		p[i] = 0xff
+	}
+	if a.Timestamp.IsZero() {
+		return
+	}
+
+	packet.SetPressure(&p, 14, a.Bar)
+	packet.SetUFloat8(&p, 29, a.ET*1000)
+	for i := uint(0); i < 2; i++ {
+		if a.ExtraHumidity[i] != nil {
+			packet.SetUInt8(&p, 43+i, *a.ExtraHumidity[i])
+		}
+	}
+	for i := uint(0); i < 3; i++ {
+		if a.ExtraTemp[i] != nil {
+			packet.SetTemp8(&p, 45+i, *a.ExtraTemp[i])
+		}
+	}
+	packet.SetForecast(&p, 33, a.Forecast)
+	packet.SetUInt8(&p, 22, a.InHumidity)
+	packet.SetFloat16_10(&p, 20, a.InTemp)
+	for i := uint(0); i < 2; i++ {
+		if a.LeafTemp[i] != nil {
+			packet.SetTemp8(&p, 34+i, *a.LeafTemp[i])
+		}
+		if a.LeafWetness[i] != nil {
+			packet.SetUInt8(&p, 36+i, *a.LeafWetness[i])
+		}
+	}
+	packet.SetUInt8(&p, 23, a.OutHumidity)
+	packet.SetFloat16_10(&p, 4, a.OutTemp)
+	packet.SetFloat16_10(&p, 6, a.OutTempHi)
+	packet.SetFloat16_10(&p, 8, a.OutTempLow)
+	packet.SetRain(&p, 10, a.RainAccum)
+	packet.SetRain(&p, 12, a.RainRateHi)
+	for i := uint(0); i < 4; i++ {
+		if a.SoilMoist[i] != nil {
+			packet.SetUInt8(&p, 48+i, *a.SoilMoist[i])
+		}
+		if a.SoilTemp[i] != nil {
+			packet.SetTemp8(&p, 38+i, *a.SoilTemp[i])
+		}
+	}
+	packet.SetUInt16(&p, 16, a.SolarRad)
+	packet.SetUInt16(&p, 30, a.SolarRadHi)
+	packet.SetDateTime32(&p, 0, a.Timestamp)
+	packet.SetUVIndex(&p, 28, a.UVIndexAvg)
+	packet.SetUVIndex(&p, 32, a.UVIndexHi)
+	packet.SetWindDir(&p, 26, a.WindDirHi)
+	packet.SetWindDir(&p, 27, a.WindDirPrevail)
+	packet.SetUInt16(&p, 18, a.WindSamples)
+	packet.SetMPH8(&p, 24, a.WindSpeedAvg)
+	packet.SetMPH8(&p, 25, a.WindSpeedHi)
+
+	packet.SetUInt8(&p, 42, 0x00) // Revision B
+
+	return
+}
+
 // Dmp is a download memory page which contains 5 archive
 // records.
 type Dmp [5]Archive
 
+// MarshalBinary encodes the Dmp array of 5 Archive records into a 267-byte
+// download memory page with a sequence number of 0.
+func (d Dmp) MarshalBinary() (p []byte, err error) {
+	p = make([]byte, 267)
+	for i := 0; i < 5; i++ {
+		var a []byte
+		a, err = d[i].MarshalBinary()
+		if err != nil {
+			return
+		}
+		copy(p[1+52*i:], a)
+	}
+	copy(p[261:], []byte{0xff, 0xff, 0xff, 0xff}) // Unused
+	packet.SetCrc(&p)
+
+	return
+}
+
 // UnmarshalBinary decodes a 267-byte download memory page into an
-// array of 5 Archive records.
+// array of 5 Archive records.  The record times are taken to be local
+// time.
 func (d *Dmp) UnmarshalBinary(p []byte) error {
+	return d.UnmarshalBinaryIn(p, time.Local)
+}
+
+// UnmarshalBinaryIn is like UnmarshalBinary but the record times are in
+// the given location, which is usually the console time zone.  Times the
+// location repeats, such as when daylight savings ends, are ambiguous
+// until they're resolved with ArcTime.
+func (d *Dmp) UnmarshalBinaryIn(p []byte, loc *time.Location) error {
 	if packet.Crc(p) != 0 {
 		return ErrBadCRC
 	} else if len(p) != 267 {
@@ -128,7 +228,7 @@ func (d *Dmp) UnmarshalBinary(p []byte) error {
 	// each one.  There are 4 unused bytes at the end.
 	for i := 0; i < 5; i++ {
 		offset := 1 + (52 * i)
-		err := d[i].UnmarshalBinary(p[offset : offset+52])
+		err := d[i].UnmarshalBinaryIn(p[offset:offset+52], loc)
 		if err == ErrNotArcB {
 			// When the archive log is clear any unwritten records of a download
 			// memory page will have the type set to 0xff (archive A).  If this
@@ -143,6 +243,48 @@ func (d *Dmp) UnmarshalBinary(p []byte) error {
 	return nil
 }
 
+// ArcTime resolves the time of an archive record, decoded in the console
+// time zone, using the time of the record before it.  Archive records only
+// have the console's local time so when the clock is set back, such as
+// when daylight savings ends, an hour of times are written twice.  Records
+// are written in time order so the earliest possible time after the
+// previous record is chosen.  If none are after it then the clock jumped
+// back and the earliest possible time is chosen.
+//
+// The resolved time is returned in UTC.
+func ArcTime(t, prev time.Time) time.Time {
+	// The possible times are the local time at each of the offsets in use
+	// around it which still read as that local time.  When the clock was
+	// set back the earlier offset gives the earlier time.
+	loc := t.Location()
+	local := wallClock(t)
+	var times []time.Time
+	for _, d := range []time.Duration{-24 * time.Hour, 24 * time.Hour} {
+		_, offset := local.Add(d).In(loc).Zone()
+		c := local.Add(-time.Duration(offset) * time.Second)
+		if wallClock(c.In(loc)).Equal(local) && (len(times) == 0 || !times[0].Equal(c)) {
+			times = append(times, c)
+		}
+	}
+
+	for _, c := range times {
+		if c.After(prev) {
+			return c.UTC()
+		}
+	}
+	if len(times) > 0 {
+		return times[0].UTC()
+	}
+
+	// The location doesn't have the local time.
+	return t.UTC()
+}
+
+// wallClock returns the local time of t as if it were UTC.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
 // Refer to Vantage Pro™, Vantage Pro2™ and Vantage Vue™ Serial
 // Communication Reference Manual, section XI. Download Protocol.
 
@@ -150,7 +292,8 @@ func (d *Dmp) UnmarshalBinary(p []byte) error {
 type DmpAft time.Time
 
 // MarshalBinary encodes the data from the DmpAft struct into a 6-byte packet
-// appropriate for use with the DMPAFT command.
+// appropriate for use with the DMPAFT command.  The time is encoded as it
+// reads in its own location so it should be in the console time zone.
 func (da DmpAft) MarshalBinary() (p []byte, err error) {
 	// 4-bytes for the time and 2-bytes for the CRC.
 	p = make([]byte, 6)
@@ -160,6 +303,24 @@ func (da DmpAft) MarshalBinary() (p []byte, err error) {
 	return
 }
 
+// UnmarshalBinary decodes a 6-byte DMPAFT packet into the DmpAft
+// timestamp.  The time is taken to be local time.
+func (da *DmpAft) UnmarshalBinary(p []byte) error {
+	return da.UnmarshalBinaryIn(p, time.Local)
+}
+
+// UnmarshalBinaryIn is like UnmarshalBinary but the time is in the given
+// location, which is usually the console time zone.
+func (da *DmpAft) UnmarshalBinaryIn(p []byte, loc *time.Location) error {
+	if packet.Crc(p) != 0 {
+		return ErrBadCRC
+	}
+
+	*da = DmpAft(packet.GetDateTime32In(p, 0, loc))
+
+	return nil
+}
+
 // DmpMeta is the DMP metadata sent after the DMPAFT command is issued.  It
 // informs the downloader how much data to expect and where the first record
 // is within the first page.
@@ -168,6 +329,17 @@ type DmpMeta struct {
 	FirstPageOffset int // Offset of the first record to read within the first page
 }
 
+// MarshalBinary encodes the DmpMeta struct into a 6-byte DMP metadata
+// packet.
+func (dm DmpMeta) MarshalBinary() (p []byte, err error) {
+	p = make([]byte, 6)
+	packet.SetUInt16(&p, 0, dm.Pages)
+	packet.SetUInt16(&p, 2, dm.FirstPageOffset)
+	packet.SetCrc(&p)
+
+	return
+}
+
 // UnmarshalBinary decodes a 6-byte DMP metadata packet into the
 // DmpMeta stuct.
 func (dm *DmpMeta) UnmarshalBinary(p []byte) error {
@@ -7,6 +7,7 @@ package data
 import (
 	"testing"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -85,3 +86,52 @@ func TestDmpMarshalBinary(t *testing.T) {
 
 	a.Equal([]byte{0xd4, 0x20, 0xd0, 0x07}, p[:len(p)-2], "Packet")
 }
+
+func TestArcTime(t *testing.T) {
+	a := assert.New(t)
+
+	// Daylight savings ended at 2:00 PDT so 1:00 to 1:59 happened twice.
+	pacific, _ := time.LoadLocation("America/Los_Angeles")
+	local := func(hour, min int) time.Time {
+		return time.Date(2016, time.November, 6, hour, min, 0, 0, pacific)
+	}
+	utc := func(hour, min int) time.Time {
+		return time.Date(2016, time.November, 6, hour, min, 0, 0, time.UTC)
+	}
+
+	var prev time.Time
+	for _, r := range []struct {
+		local, utc time.Time
+	}{
+		{local(0, 55), utc(7, 55)},
+		{local(1, 0), utc(8, 0)},
+		{local(1, 55), utc(8, 55)},
+		{local(1, 0), utc(9, 0)},
+		{local(1, 55), utc(9, 55)},
+		{local(2, 0), utc(10, 0)},
+	} {
+		prev = ArcTime(r.local, prev)
+		a.Equal(r.utc, prev, "Fall back")
+	}
+
+	a.Equal(utc(8, 30), ArcTime(local(1, 30), time.Time{}), "No previous record")
+	a.Equal(utc(8, 30), ArcTime(local(1, 30), utc(12, 0)), "Clock jumped back")
+	a.Equal(utc(20, 0), ArcTime(local(12, 0), utc(12, 0)), "Unambiguous")
+}
+
+func TestDmpMarshalBinaryRoundTrip(t *testing.T) {
+	a := assert.New(t)
+
+	d := Dmp{}
+	err := d.UnmarshalBinary(testDmpPackets["std"])
+	a.Nil(err, "UnmarshalBinary Dmp")
+
+	p, err := d.MarshalBinary()
+	a.Nil(err, "MarshalBinary Dmp")
+	a.Equal(267, len(p), "Packet length")
+
+	d2 := Dmp{}
+	err = d2.UnmarshalBinary(p)
+	a.Nil(err, "UnmarshalBinary marshaled Dmp")
+	a.Equal(d, d2, "Round trip")
+}
@@ -11,27 +11,700 @@ package data
 // configuration settings.
 
 import (
+	"fmt"
+	"math"
+	"reflect"
 	"time"
 
 	"github.com/ebarkie/weatherlink/packet"
 	"github.com/ebarkie/weatherlink/units"
 )
 
+// EEPROM regions.
+const (
+	eeImageSize = 4096 // Size of the EEPROM image
+
+	eeCal     = 50 // Calibration offsets
+	eeCalSize = 29
+)
+
 // EEPROM represents the configuration settings.
+//
+// The yearly rain and ET totals the console starts counting from aren't
+// kept in the EEPROM so they aren't included.  They're set with the
+// PUTRAIN and PUTET commands.
 type EEPROM struct {
-	ArchivePeriod int           `json:"archivePeriod"`
-	Elev          int           `json:"elevation"`
-	Lat           float64       `json:"latitude"`
-	Lon           float64       `json:"longitude"`
-	TimeOffset    time.Duration `json:"timeOffset"`
+	Alarms          EEAlarms      `json:"alarms"`
+	ArchivePeriod   int           `json:"archivePeriod"`
+	Cal             EECal         `json:"calibration"`
+	Elev            int           `json:"elevation"`
+	Lat             float64       `json:"latitude"`
+	LogAvgTemp      bool          `json:"logAverageTemperature"`
+	Lon             float64       `json:"longitude"`
+	RainSeasonStart time.Month    `json:"rainSeasonStart"`
+	Retransmit      int           `json:"retransmitID"`
+	Setup           EESetup       `json:"setup"`
+	Stations        [8]EEStation  `json:"stations"`
+	TimeOffset      time.Duration `json:"timeOffset"`
+	TimeZone        EETimeZone    `json:"timeZone"`
+	Units           EEUnits       `json:"units"`
+
+	raw []byte // Image it was decoded from
+}
+
+// EEAlarms is the alarm thresholds.  Thresholds which aren't set are nil.
+type EEAlarms struct {
+	BarFall          *float64  `json:"barometerFall,omitempty"`
+	BarRise          *float64  `json:"barometerRise,omitempty"`
+	DewPointHi       *int      `json:"dewPointHi,omitempty"`
+	DewPointLow      *int      `json:"dewPointLow,omitempty"`
+	ETDay            *float64  `json:"dayET,omitempty"`
+	ExtraHumidityHi  [7]*int   `json:"extraHumidityHi"`
+	ExtraHumidityLow [7]*int   `json:"extraHumidityLow"`
+	ExtraTempHi      [7]*int   `json:"extraTemperatureHi"`
+	ExtraTempLow     [7]*int   `json:"extraTemperatureLow"`
+	HeatIndex        *int      `json:"heatIndex,omitempty"`
+	InHumidityHi     *int      `json:"insideHumidityHi,omitempty"`
+	InHumidityLow    *int      `json:"insideHumidityLow,omitempty"`
+	InTempHi         *int      `json:"insideTemperatureHi,omitempty"`
+	InTempLow        *int      `json:"insideTemperatureLow,omitempty"`
+	LeafTempHi       [4]*int   `json:"leafTemperatureHi"`
+	LeafTempLow      [4]*int   `json:"leafTemperatureLow"`
+	LeafWetnessHi    [4]*int   `json:"leafWetnessHi"`
+	LeafWetnessLow   [4]*int   `json:"leafWetnessLow"`
+	OutHumidityHi    *int      `json:"outsideHumidityHi,omitempty"`
+	OutHumidityLow   *int      `json:"outsideHumidityLow,omitempty"`
+	OutTempHi        *int      `json:"outsideTemperatureHi,omitempty"`
+	OutTempLow       *int      `json:"outsideTemperatureLow,omitempty"`
+	Rain15Min        *float64  `json:"rain15Min,omitempty"`
+	Rain24Hour       *float64  `json:"rain24Hour,omitempty"`
+	RainRate         *float64  `json:"rainRate,omitempty"`
+	RainStorm        *float64  `json:"rainStorm,omitempty"`
+	SoilMoistHi      [4]*int   `json:"soilMoistureHi"`
+	SoilMoistLow     [4]*int   `json:"soilMoistureLow"`
+	SoilTempHi       [4]*int   `json:"soilTemperatureHi"`
+	SoilTempLow      [4]*int   `json:"soilTemperatureLow"`
+	SolarRad         *int      `json:"solarRadiation,omitempty"`
+	THSWIndex        *int      `json:"THSWIndex,omitempty"`
+	Time             time.Time `json:"time,omitempty"`
+	UVIndex          *float64  `json:"UVIndex,omitempty"`
+	WindChill        *int      `json:"windChill,omitempty"`
+	WindSpeed        *int      `json:"windSpeed,omitempty"`
+	WindSpeed10Min   *int      `json:"windSpeed10Min,omitempty"`
+}
+
+// EECal is the calibration offsets added to sensor readings.
+type EECal struct {
+	ExtraHumidity [7]int     `json:"extraHumidity"`
+	ExtraTemp     [7]float64 `json:"extraTemperature"`
+	InHumidity    int        `json:"insideHumidity"`
+	InTemp        float64    `json:"insideTemperature"`
+	LeafTemp      [4]float64 `json:"leafTemperature"`
+	OutHumidity   int        `json:"outsideHumidity"`
+	OutTemp       float64    `json:"outsideTemperature"`
+	SoilTemp      [4]float64 `json:"soilTemperature"`
+	WindDir       int        `json:"windDirection"`
+}
+
+// MarshalBinary encodes the calibration offsets into the 29-byte
+// calibration region of the EEPROM.
+func (c EECal) MarshalBinary() (p []byte, err error) {
+	p = make([]byte, eeCalSize)
+	temp := func(i uint, v float64) { packet.SetUInt8(&p, i, int(int8(math.Round(v*10.0)))) }
+	temp(0, c.InTemp)
+	packet.SetUInt8(&p, 1, ^packet.GetUInt8(p, 0)&0xff) // Complement
+	temp(2, c.OutTemp)
+	for i := range c.ExtraTemp {
+		temp(uint(3+i), c.ExtraTemp[i])
+	}
+	for i := range c.SoilTemp {
+		temp(uint(10+i), c.SoilTemp[i])
+	}
+	for i := range c.LeafTemp {
+		temp(uint(14+i), c.LeafTemp[i])
+	}
+	packet.SetUInt8(&p, 18, c.InHumidity)
+	packet.SetUInt8(&p, 19, c.OutHumidity)
+	for i := range c.ExtraHumidity {
+		packet.SetUInt8(&p, uint(20+i), c.ExtraHumidity[i])
+	}
+	packet.SetFloat16(&p, 27, float64(c.WindDir))
+
+	return
+}
+
+// UnmarshalBinary decodes the 29-byte calibration region of the EEPROM
+// into the EECal struct.
+func (c *EECal) UnmarshalBinary(p []byte) error {
+	if len(p) != eeCalSize {
+		return ErrNotCal
+	}
+
+	// Calibration offsets are signed, temperatures in tenths.
+	cal := func(i int) int { return int(int8(p[i])) }
+	c.InTemp = float64(cal(0)) / 10.0
+	c.OutTemp = float64(cal(2)) / 10.0
+	for i := range c.ExtraTemp {
+		c.ExtraTemp[i] = float64(cal(3+i)) / 10.0
+	}
+	for i := range c.SoilTemp {
+		c.SoilTemp[i] = float64(cal(10+i)) / 10.0
+	}
+	for i := range c.LeafTemp {
+		c.LeafTemp[i] = float64(cal(14+i)) / 10.0
+	}
+	c.InHumidity = cal(18)
+	c.OutHumidity = cal(19)
+	for i := range c.ExtraHumidity {
+		c.ExtraHumidity[i] = cal(20 + i)
+	}
+	c.WindDir = int(packet.GetFloat16(p, 27))
+
+	return nil
+}
+
+// EESetup is the console setup.
+type EESetup struct {
+	AM            bool   `json:"AM"`            // Clock is AM, in 12 hour mode
+	Clock24Hour   bool   `json:"clock24Hour"`   // Clock is 24 hour rather than AM/PM
+	DayMonth      bool   `json:"dayMonth"`      // Dates are day/month rather than month/day
+	RainCollector string `json:"rainCollector"` // 0.01in, 0.2mm, or 0.1mm
+	WindCupSize   string `json:"windCupSize"`   // Small, Large, or Other
+}
+
+// EEStation is a transmitter in the station list.
+type EEStation struct {
+	Active     bool   `json:"active"` // Console listens to it
+	HumSensor  *int   `json:"humiditySensor,omitempty"`
+	ID         int    `json:"ID"`
+	Repeater   string `json:"repeater,omitempty"` // Repeater it's received through, A-H
+	TempSensor *int   `json:"temperatureSensor,omitempty"`
+	Type       string `json:"type"`
+}
+
+// EETimeZone is the time zone and daylight savings settings.
+type EETimeZone struct {
+	DST       bool          `json:"DST"`       // Daylight savings is on, when it's set manually
+	DSTManual bool          `json:"DSTManual"` // Daylight savings is set manually rather than automatically
+	Name      string        `json:"name,omitempty"`
+	Offset    time.Duration `json:"offset"`    // GMT offset in use, without daylight savings
+	UseOffset bool          `json:"useOffset"` // Offset is custom rather than from the preset zone
+	Zone      int           `json:"zone"`      // Preset zone index
+}
+
+// EEUnits is the units the console displays.
+type EEUnits struct {
+	Bar        string `json:"barometer"`         // in, mm, hPa, or mb
+	Elev       string `json:"elevation"`         // ft or m
+	Rain       string `json:"rain"`              // in or mm
+	Temp       string `json:"temperature"`       // F or C
+	TempTenths bool   `json:"temperatureTenths"` // Temperature is shown in tenths
+	Wind       string `json:"wind"`              // mph, m/s, km/h, or knots
+}
+
+// Unit names by their unit bits value.
+var (
+	barUnits  = []string{"in", "mm", "hPa", "mb"}
+	elevUnits = []string{"ft", "m"}
+	rainUnits = []string{"in", "mm"}
+	tempUnits = []string{"F", "C"}
+	windUnits = []string{"mph", "m/s", "km/h", "knots"}
+)
+
+// Rain collector sizes by their setup bits value.
+var (
+	rainCollectors = []string{"0.01in", "0.2mm", "0.1mm"}
+	rainClicks     = []float64{0.01, 0.2 / 25.4, 0.1 / 25.4} // Inches
+)
+
+// Wind cup sizes by their value.
+var windCupSizes = []string{"", "Small", "Large", "Other"}
+
+// stationTypes are the Rev B station list transmitter types.
+var stationTypes = []string{
+	"ISS",        // 0
+	"Temp",       // 1
+	"Hum",        // 2
+	"Temp/Hum",   // 3
+	"Wind",       // 4
+	"Rain",       // 5
+	"Leaf",       // 6
+	"Soil",       // 7
+	"Soil/Leaf",  // 8
+	"SensorLink", // 9
+	"None",       // 10
+}
+
+// timeZones are the preset time zones.
+var timeZones = []struct {
+	offset int // hours * 100 + minutes
+	name   string
+	loc    string // IANA time zone the console observes daylight savings like
+}{
+	{-1200, "Eniwetok, Kwajalein", "Etc/GMT+12"},
+	{-1100, "Midway Island, Samoa", "Pacific/Midway"},
+	{-1000, "Hawaii", "Pacific/Honolulu"},
+	{-900, "Alaska", "America/Anchorage"},
+	{-800, "Pacific Time, Tijuana", "America/Los_Angeles"},
+	{-700, "Mountain Time", "America/Denver"},
+	{-600, "Central Time", "America/Chicago"},
+	{-600, "Mexico City", "America/Mexico_City"},
+	{-600, "Central America", "America/Guatemala"},
+	{-500, "Bogota, Lima, Quito", "America/Bogota"},
+	{-500, "Eastern Time", "America/New_York"},
+	{-400, "Atlantic Time", "America/Halifax"},
+	{-400, "Caracas, La Paz, Santiago", "America/La_Paz"},
+	{-330, "Newfoundland", "America/St_Johns"},
+	{-300, "Brasilia", "America/Sao_Paulo"},
+	{-300, "Buenos Aires, Georgetown, Greenland", "America/Argentina/Buenos_Aires"},
+	{-200, "Mid-Atlantic", "Atlantic/South_Georgia"},
+	{-100, "Azores, Cape Verde Is.", "Atlantic/Azores"},
+	{0, "Greenwich Mean Time, Dublin, Edinburgh, Lisbon, London", "Europe/London"},
+	{0, "Monrovia, Casablanca", "Africa/Monrovia"},
+	{100, "Berlin, Rome, Amsterdam, Bern, Stockholm, Vienna", "Europe/Berlin"},
+	{100, "Paris, Madrid, Brussels, Copenhagen, W Central Africa", "Europe/Paris"},
+	{100, "Prague, Belgrade, Bratislava, Budapest, Ljubljana", "Europe/Prague"},
+	{200, "Athens, Helsinki, Istanbul, Minsk, Riga, Tallinn", "Europe/Athens"},
+	{200, "Cairo", "Africa/Cairo"},
+	{200, "Eastern Europe, Bucharest", "Europe/Bucharest"},
+	{200, "Harare, Pretoria", "Africa/Harare"},
+	{200, "Israel, Jerusalem", "Asia/Jerusalem"},
+	{300, "Baghdad, Kuwait, Nairobi, Riyadh", "Asia/Riyadh"},
+	{300, "Moscow, St. Petersburg, Volgograd", "Europe/Moscow"},
+	{330, "Tehran", "Asia/Tehran"},
+	{400, "Abu Dhabi, Muscat, Baku, Tblisi, Yerevan, Kazan", "Asia/Dubai"},
+	{430, "Kabul", "Asia/Kabul"},
+	{500, "Islamabad, Karachi, Ekaterinburg, Tashkent", "Asia/Karachi"},
+	{530, "Bombay, Calcutta, Madras, New Delhi, Chennai", "Asia/Kolkata"},
+	{600, "Almaty, Dhaka, Colombo, Novosibirsk, Astana", "Asia/Dhaka"},
+	{700, "Bangkok, Jakarta, Hanoi, Krasnoyarsk", "Asia/Bangkok"},
+	{800, "Beijing, Chongqing, Urumqi, Irkutsk, Ulaan Bataar", "Asia/Shanghai"},
+	{800, "Hong Kong, Perth, Singapore, Taipei, Kuala Lumpur", "Asia/Singapore"},
+	{900, "Tokyo, Osaka, Sapporo, Seoul, Yakutsk", "Asia/Tokyo"},
+	{930, "Adelaide", "Australia/Adelaide"},
+	{930, "Darwin", "Australia/Darwin"},
+	{1000, "Brisbane, Melbourne, Sydney, Canberra", "Australia/Sydney"},
+	{1000, "Hobart, Guam, Port Moresby, Vladivostok", "Australia/Hobart"},
+	{1100, "Magadan, Solomon Is, New Caledonia", "Pacific/Guadalcanal"},
+	{1200, "Fiji, Kamchatka, Marshall Is.", "Pacific/Fiji"},
+	{1200, "Wellington, Auckland", "Pacific/Auckland"},
+}
+
+// MarshalBinary encodes the station into the 2-byte station list entry
+// for its ID.  Whether it's active is kept separately so it isn't
+// included.
+func (s EEStation) MarshalBinary() (p []byte, err error) {
+	t := -1
+	for i := range stationTypes {
+		if s.Type == stationTypes[i] {
+			t = i
+		}
+	}
+	if t < 0 {
+		return nil, ErrUnknownStation
+	}
+
+	var r int
+	if s.Repeater != "" {
+		if len(s.Repeater) != 1 || s.Repeater[0] < 'A' || s.Repeater[0] > 'H' {
+			return nil, ErrUnknownStation
+		}
+		r = int(s.Repeater[0]-'A') + 8
+	}
+
+	// Sensor numbers which aren't used are stored as all ones.
+	temp, hum := 0x0f, 0x0f
+	if s.TempSensor != nil {
+		temp = *s.TempSensor & 0x0f
+	}
+	if s.HumSensor != nil {
+		hum = *s.HumSensor & 0x0f
+	}
+
+	p = make([]byte, 2)
+	packet.SetUInt8(&p, 0, r<<4|t)
+	packet.SetUInt8(&p, 1, hum<<4|temp)
+
+	return
+}
+
+// MarshalBinary encodes the time zone settings into the 6 bytes starting
+// at the time zone address.
+func (tz EETimeZone) MarshalBinary() (p []byte, err error) {
+	const maxOffset = 14 * time.Hour
+
+	if (!tz.UseOffset && (tz.Zone < 0 || tz.Zone >= len(timeZones))) ||
+		tz.Offset < -maxOffset || tz.Offset > maxOffset {
+		return nil, ErrBadTimeZone
+	}
+
+	offset := int(tz.Offset/time.Hour)*100 + int(tz.Offset%time.Hour/time.Minute)
+	if !tz.UseOffset {
+		offset = timeZones[tz.Zone].offset
+	}
+
+	p = make([]byte, 6)
+	packet.SetUInt8(&p, 0, tz.Zone)
+	packet.SetUInt8(&p, 1, boolInt(tz.DSTManual))
+	packet.SetUInt8(&p, 2, boolInt(tz.DST))
+	packet.SetUInt16(&p, 3, offset)
+	packet.SetUInt8(&p, 5, boolInt(tz.UseOffset))
+
+	return
+}
+
+// UnmarshalBinary decodes the 6 bytes starting at the time zone address
+// into the EETimeZone struct.
+func (tz *EETimeZone) UnmarshalBinary(p []byte) error {
+	if len(p) != 6 {
+		return ErrBadTimeZone
+	}
+
+	*tz = EETimeZone{
+		Zone:      packet.GetUInt8(p, 0),
+		DSTManual: packet.GetUInt8(p, 1) == 1,
+		DST:       packet.GetUInt8(p, 2) == 1,
+		UseOffset: packet.GetUInt8(p, 5) == 1,
+	}
+	if tz.UseOffset {
+		tz.Offset = gmtOffset(int(packet.GetFloat16(p, 3)))
+	} else if tz.Zone < len(timeZones) {
+		tz.Name = timeZones[tz.Zone].name
+		tz.Offset = gmtOffset(timeZones[tz.Zone].offset)
+	}
+
+	return nil
+}
+
+// Location returns the location the console keeps time in.
+//
+// When daylight savings is automatic the console follows the rules for the
+// preset zone, so the IANA time zone for it is used if the time zone
+// database is available.  Otherwise it's a fixed offset, including
+// daylight savings if it's manually on, which can't tell when daylight
+// savings was changed.
+func (tz EETimeZone) Location() *time.Location {
+	offset := tz.Offset
+	if !tz.UseOffset && tz.Zone >= 0 && tz.Zone < len(timeZones) {
+		if !tz.DSTManual {
+			if loc, err := time.LoadLocation(timeZones[tz.Zone].loc); err == nil {
+				return loc
+			}
+		}
+		offset = gmtOffset(timeZones[tz.Zone].offset)
+	}
+	if tz.DSTManual && tz.DST {
+		offset += time.Hour
+	}
+
+	name := tz.Name
+	if name == "" {
+		name = time.Unix(0, 0).In(time.FixedZone("", int(offset/time.Second))).Format("GMT-07:00")
+	}
+
+	return time.FixedZone(name, int(offset/time.Second))
+}
+
+// boolInt converts a bool to 1 if it's true or 0 if it's false.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// gmtOffset converts a GMT offset stored as hours * 100 + minutes to a
+// duration.
+func gmtOffset(v int) time.Duration {
+	return time.Duration(v/100)*time.Hour + time.Duration(v%100)*time.Minute
+}
+
+// MarshalBinary encodes the EEPROM struct into a 4096-byte EEPROM image.
+//
+// If the struct was decoded from an image then that image is the starting
+// point and only settings which changed are encoded, so the undocumented
+// regions and any bits which don't survive decoding are kept as they
+// were.  Otherwise the settings are encoded over an erased image.
+func (ee EEPROM) MarshalBinary() (p []byte, err error) {
+	var orig EEPROM
+	if len(ee.raw) == eeImageSize {
+		p = append([]byte{}, ee.raw...)
+		if err = orig.UnmarshalBinary(ee.raw); err != nil {
+			return nil, err
+		}
+	} else {
+		p = make([]byte, eeImageSize)
+		for i := range p {
+			p[i] = 0xff
+		}
+	}
+	changed := func(a, b interface{}) bool {
+		return orig.raw == nil || !reflect.DeepEqual(a, b)
+	}
+
+	if changed(orig.Units, ee.Units) {
+		if err = ee.Units.marshal(&p); err != nil {
+			return nil, err
+		}
+	}
+	if changed(orig.Setup, ee.Setup) {
+		if err = ee.Setup.marshal(&p); err != nil {
+			return nil, err
+		}
+	}
+
+	if changed(orig.ArchivePeriod, ee.ArchivePeriod) {
+		packet.SetUInt8(&p, 45, ee.ArchivePeriod)
+	}
+	if changed(orig.RainSeasonStart, ee.RainSeasonStart) {
+		packet.SetUInt8(&p, 44, int(ee.RainSeasonStart))
+	}
+	if changed(orig.LogAvgTemp, ee.LogAvgTemp) {
+		packet.SetUInt8(&p, 4092, 0xff)
+		if ee.LogAvgTemp {
+			packet.SetUInt8(&p, 4092, 0)
+		}
+	}
+
+	// Location
+	if changed(orig.Elev, ee.Elev) {
+		elev := float64(ee.Elev)
+		if packet.GetUInt8(p, 41)&0x10 != 0 {
+			// Elevation is in meters so convert from feet
+			elev = units.Length(elev * 12.0).Meters()
+		}
+		packet.SetUInt16(&p, 15, int(math.Round(elev)))
+	}
+	if changed([]float64{orig.Lat, orig.Lon}, []float64{ee.Lat, ee.Lon}) {
+		packet.SetFloat16_10(&p, 11, ee.Lat)
+		packet.SetFloat16_10(&p, 13, ee.Lon)
+		setup := packet.GetUInt8(p, 43) &^ 0xc0
+		if ee.Lat >= 0.0 {
+			setup |= 0x40
+		}
+		if ee.Lon >= 0.0 {
+			setup |= 0x80
+		}
+		packet.SetUInt8(&p, 43, setup)
+	}
+
+	// Time zone.  The time offset is kept for compatibility and the time
+	// zone settings take precedence if both changed.
+	if changed(orig.TimeOffset, ee.TimeOffset) {
+		packet.SetFloat16(&p, 20, ee.TimeOffset.Hours()*100.0)
+	}
+	if changed(orig.TimeZone, ee.TimeZone) {
+		var b []byte
+		if b, err = ee.TimeZone.MarshalBinary(); err != nil {
+			return nil, err
+		}
+		copy(p[17:], b)
+	}
+
+	// Station list
+	if changed(orig.Retransmit, ee.Retransmit) {
+		packet.SetUInt8(&p, 24, ee.Retransmit)
+	}
+	if changed(orig.Stations, ee.Stations) {
+		var useTx int
+		for i, s := range ee.Stations {
+			if s.Active {
+				useTx |= 1 << uint(i)
+			}
+			var b []byte
+			if b, err = s.MarshalBinary(); err != nil {
+				return nil, err
+			}
+			copy(p[25+i*2:], b)
+		}
+		packet.SetUInt8(&p, 23, useTx)
+	}
+
+	if changed(orig.Cal, ee.Cal) {
+		b, _ := ee.Cal.MarshalBinary()
+		copy(p[eeCal:], b)
+	}
+	if changed(orig.Alarms, ee.Alarms) {
+		ee.Alarms.marshal(&p, rainClicks[rainCollector(packet.GetUInt8(p, 43))])
+	}
+
+	return
+}
+
+// marshal encodes the units into the unit bits of an EEPROM image.
+func (u EEUnits) marshal(p *[]byte) error {
+	bar, elev, rain := index(barUnits, u.Bar), index(elevUnits, u.Elev), index(rainUnits, u.Rain)
+	temp, wind := index(tempUnits, u.Temp), index(windUnits, u.Wind)
+	if bar < 0 || elev < 0 || rain < 0 || temp < 0 || wind < 0 {
+		return ErrUnknownUnit
+	}
+
+	unit := wind<<6 | rain<<5 | elev<<4 | temp<<3 | bar
+	if u.TempTenths {
+		unit |= 0x04
+	}
+	packet.SetUInt8(p, 41, unit)
+	packet.SetUInt8(p, 42, ^unit&0xff)
+
+	return nil
+}
+
+// marshal encodes the setup into the setup bits, except for the
+// hemispheres, and wind cup size of an EEPROM image.
+func (s EESetup) marshal(p *[]byte) error {
+	rc, cup := index(rainCollectors, s.RainCollector), index(windCupSizes, s.WindCupSize)
+	if rc < 0 || cup < 1 {
+		return ErrUnknownUnit
+	}
+
+	setup := packet.GetUInt8(*p, 43)&0xc0 | rc<<4
+	if s.Clock24Hour {
+		setup |= 0x01
+	}
+	if s.AM {
+		setup |= 0x02
+	}
+	if s.DayMonth {
+		setup |= 0x04
+	}
+	if s.WindCupSize == "Large" {
+		setup |= 0x08
+	}
+	packet.SetUInt8(p, 43, setup)
+
+	// Consoles which keep the wind cup size separately have it set.
+	if c := packet.GetUInt8(*p, 195); c&0x03 != 0 || s.WindCupSize == "Other" {
+		packet.SetUInt8(p, 195, c&^0x03|cup)
+	}
+
+	return nil
+}
+
+// marshal encodes the alarm thresholds into an EEPROM image.  Rain
+// thresholds are converted to clicks of the given size in inches.
+func (a EEAlarms) marshal(p *[]byte, rainClick float64) {
+	setAlarmBar(p, 82, a.BarRise)
+	setAlarmBar(p, 83, a.BarFall)
+	packet.SetTime16(p, 84, a.Time)
+	packet.SetUInt16(p, 86, ^packet.GetUInt16(*p, 84)&0xffff) // Complement
+	setAlarm8(p, 88, a.InTempLow, 90)
+	setAlarm8(p, 89, a.InTempHi, 90)
+	setAlarm8(p, 90, a.OutTempLow, 90)
+	setAlarm8(p, 91, a.OutTempHi, 90)
+	for i := uint(0); i < 7; i++ {
+		setAlarm8(p, 92+i, a.ExtraTempLow[i], 90)
+		setAlarm8(p, 107+i, a.ExtraTempHi[i], 90)
+		setAlarm8(p, 125+i, a.ExtraHumidityLow[i], 0)
+		setAlarm8(p, 133+i, a.ExtraHumidityHi[i], 0)
+	}
+	for i := uint(0); i < 4; i++ {
+		setAlarm8(p, 99+i, a.SoilTempLow[i], 90)
+		setAlarm8(p, 103+i, a.LeafTempLow[i], 90)
+		setAlarm8(p, 114+i, a.SoilTempHi[i], 90)
+		setAlarm8(p, 118+i, a.LeafTempHi[i], 90)
+		setAlarm8(p, 149+i, a.SoilMoistLow[i], 0)
+		setAlarm8(p, 153+i, a.SoilMoistHi[i], 0)
+		setAlarm8(p, 157+i, a.LeafWetnessLow[i], 0)
+		setAlarm8(p, 161+i, a.LeafWetnessHi[i], 0)
+	}
+	setAlarm8(p, 122, a.InHumidityLow, 0)
+	setAlarm8(p, 123, a.InHumidityHi, 0)
+	setAlarm8(p, 124, a.OutHumidityLow, 0)
+	setAlarm8(p, 132, a.OutHumidityHi, 0)
+	setAlarm8(p, 140, a.DewPointLow, 120)
+	setAlarm8(p, 141, a.DewPointHi, 120)
+	setAlarm8(p, 142, a.WindChill, 120)
+	setAlarm8(p, 143, a.HeatIndex, 90)
+	setAlarm8(p, 144, a.THSWIndex, 90)
+	setAlarm8(p, 145, a.WindSpeed, 0)
+	setAlarm8(p, 146, a.WindSpeed10Min, 0)
+	setAlarm8(p, 147, scaleAlarm(a.UVIndex, 0.1), 0)
+	setAlarm16(p, 165, a.SolarRad)
+	setAlarm16(p, 167, scaleAlarm(a.RainRate, rainClick))
+	setAlarm16(p, 169, scaleAlarm(a.Rain15Min, rainClick))
+	setAlarm16(p, 171, scaleAlarm(a.Rain24Hour, rainClick))
+	setAlarm16(p, 173, scaleAlarm(a.RainStorm, rainClick))
+	setAlarm8(p, 175, scaleAlarm(a.ETDay, 0.001), 0)
+}
+
+// setAlarmBar encodes a 1-byte barometer trend alarm threshold.
+func setAlarmBar(p *[]byte, i uint, v *float64) {
+	if v == nil {
+		packet.SetUInt8(p, i, 0)
+		return
+	}
+
+	packet.SetUFloat8(p, i, *v*1000.0)
+}
+
+// setAlarm8 encodes a 1-byte alarm threshold stored with an offset.
+func setAlarm8(p *[]byte, i uint, v *int, offset int) {
+	if v == nil {
+		packet.SetUInt8(p, i, 0xff)
+		return
+	}
+
+	packet.SetUInt8(p, i, *v+offset)
+}
+
+// setAlarm16 encodes a 2-byte alarm threshold.  Consoles use more than
+// one value for thresholds which aren't set so an existing one is kept.
+func setAlarm16(p *[]byte, i uint, v *int) {
+	if v == nil {
+		if cur := packet.GetUInt16(*p, i); cur != 0xffff && cur != 0x7fff {
+			packet.SetUInt16(p, i, 0xffff)
+		}
+		return
+	}
+
+	packet.SetUInt16(p, i, *v)
+}
+
+// scaleAlarm converts an alarm threshold to units of the given scale.
+func scaleAlarm(v *float64, scale float64) *int {
+	if v == nil {
+		return nil
+	}
+	n := int(math.Round(*v / scale))
+
+	return &n
+}
+
+// rainCollector returns the rain collector size from the setup bits.
+func rainCollector(setup int) int {
+	if rc := setup >> 4 & 0x03; rc < len(rainCollectors) {
+		return rc
+	}
+
+	return 0
+}
+
+// index returns the index of a string in a list or -1 if it's not
+// present.
+func index(list []string, s string) int {
+	for i := range list {
+		if list[i] == s {
+			return i
+		}
+	}
+
+	return -1
 }
 
-// UnmarshalBinary decodes a 4096-byte EEPROM packet into the
-// EEPROM struct.
+// UnmarshalBinary decodes a 4096-byte EEPROM image, or a GETEE packet
+// which is the image followed by its CRC, into the EEPROM struct.
 func (ee *EEPROM) UnmarshalBinary(p []byte) error {
-	if packet.Crc(p) != 0 {
-		return ErrBadCRC
+	switch {
+	case len(p) == eeImageSize+2:
+		if packet.Crc(p) != 0 {
+			return ErrBadCRC
+		}
+		p = p[:eeImageSize]
+	case len(p) != eeImageSize:
+		return ErrNotEEPROM
 	}
+	ee.raw = append([]byte{}, p...)
 
 	// Setup bit breakdown:
 	//
@@ -77,5 +750,228 @@ func (ee *EEPROM) UnmarshalBinary(p []byte) error {
 
 	ee.TimeOffset = time.Duration(packet.GetFloat16(p, 20)/100.0) * time.Hour
 
+	// Time zone
+	ee.TimeZone.UnmarshalBinary(p[17:23])
+
+	// Station list breakdown, 2 bytes per transmitter ID:
+	//
+	// Byte 0                         | Byte 1
+	// -------------------------------+-------------------------------
+	//  7-4         | 3-0             | 7-4            | 3-0
+	//  Repeater ID | Station type    | Humidity #     | Temperature #
+	//  0 = none    | (Rev B)         |                |
+	//  8-15 = A-H  |                 |                |
+	useTx := packet.GetUInt8(p, 23)
+	ee.Retransmit = packet.GetUInt8(p, 24)
+	for i := range ee.Stations {
+		s := &ee.Stations[i]
+		s.ID = i + 1
+		s.Active = useTx&(1<<uint(i)) != 0
+
+		b0, b1 := packet.GetUInt8(p, uint(25+i*2)), packet.GetUInt8(p, uint(26+i*2))
+		if t := b0 & 0x0f; t < len(stationTypes) {
+			s.Type = stationTypes[t]
+		}
+		if r := b0 >> 4; r >= 8 {
+			s.Repeater = string(rune('A' + r - 8))
+		}
+		// The sensor numbers determine where extra temperatures and
+		// humidities are logged and are only used by stations which
+		// have them.
+		if s.Type == "Temp" || s.Type == "Temp/Hum" {
+			temp := b1 & 0x0f
+			s.TempSensor = &temp
+		}
+		if s.Type == "Hum" || s.Type == "Temp/Hum" {
+			hum := b1 >> 4
+			s.HumSensor = &hum
+		}
+	}
+
+	// Units
+	ee.Units.Bar = barUnits[unit&0x03]
+	ee.Units.Temp = tempUnits[unit>>3&0x01]
+	ee.Units.TempTenths = unit&0x04 != 0
+	ee.Units.Elev = elevUnits[unit>>4&0x01]
+	ee.Units.Rain = rainUnits[unit>>5&0x01]
+	ee.Units.Wind = windUnits[unit>>6&0x03]
+
+	// Setup
+	ee.Setup.Clock24Hour = setup&0x01 != 0
+	ee.Setup.AM = setup&0x02 != 0
+	ee.Setup.DayMonth = setup&0x04 != 0
+	rc := rainCollector(setup)
+	ee.Setup.RainCollector = rainCollectors[rc]
+	rainClick := rainClicks[rc]
+	// Vantage Pro2 and Vue consoles keep the wind cup size separately
+	// so it can be other than small or large.
+	if cup := packet.GetUInt8(p, 195) & 0x03; cup != 0 {
+		ee.Setup.WindCupSize = windCupSizes[cup]
+	} else if setup&0x08 != 0 {
+		ee.Setup.WindCupSize = "Large"
+	} else {
+		ee.Setup.WindCupSize = "Small"
+	}
+
+	ee.RainSeasonStart = time.Month(packet.GetUInt8(p, 44))
+	ee.LogAvgTemp = packet.GetUInt8(p, 4092) == 0
+
+	if err := ee.Cal.UnmarshalBinary(p[eeCal : eeCal+eeCalSize]); err != nil {
+		return err
+	}
+
+	// Alarm thresholds
+	ee.Alarms.BarRise = eeAlarmBar(p, 82)
+	ee.Alarms.BarFall = eeAlarmBar(p, 83)
+	if packet.GetUInt16(p, 84) != 0xffff {
+		ee.Alarms.Time = packet.GetTime16(p, 84)
+	}
+	ee.Alarms.InTempLow = eeAlarm8(p, 88, 90)
+	ee.Alarms.InTempHi = eeAlarm8(p, 89, 90)
+	ee.Alarms.OutTempLow = eeAlarm8(p, 90, 90)
+	ee.Alarms.OutTempHi = eeAlarm8(p, 91, 90)
+	for i := uint(0); i < 7; i++ {
+		ee.Alarms.ExtraTempLow[i] = eeAlarm8(p, 92+i, 90)
+		ee.Alarms.ExtraTempHi[i] = eeAlarm8(p, 107+i, 90)
+		ee.Alarms.ExtraHumidityLow[i] = eeAlarm8(p, 125+i, 0)
+		ee.Alarms.ExtraHumidityHi[i] = eeAlarm8(p, 133+i, 0)
+	}
+	for i := uint(0); i < 4; i++ {
+		ee.Alarms.SoilTempLow[i] = eeAlarm8(p, 99+i, 90)
+		ee.Alarms.LeafTempLow[i] = eeAlarm8(p, 103+i, 90)
+		ee.Alarms.SoilTempHi[i] = eeAlarm8(p, 114+i, 90)
+		ee.Alarms.LeafTempHi[i] = eeAlarm8(p, 118+i, 90)
+		ee.Alarms.SoilMoistLow[i] = eeAlarm8(p, 149+i, 0)
+		ee.Alarms.SoilMoistHi[i] = eeAlarm8(p, 153+i, 0)
+		ee.Alarms.LeafWetnessLow[i] = eeAlarm8(p, 157+i, 0)
+		ee.Alarms.LeafWetnessHi[i] = eeAlarm8(p, 161+i, 0)
+	}
+	ee.Alarms.InHumidityLow = eeAlarm8(p, 122, 0)
+	ee.Alarms.InHumidityHi = eeAlarm8(p, 123, 0)
+	ee.Alarms.OutHumidityLow = eeAlarm8(p, 124, 0)
+	ee.Alarms.OutHumidityHi = eeAlarm8(p, 132, 0)
+	ee.Alarms.DewPointLow = eeAlarm8(p, 140, 120)
+	ee.Alarms.DewPointHi = eeAlarm8(p, 141, 120)
+	ee.Alarms.WindChill = eeAlarm8(p, 142, 120)
+	ee.Alarms.HeatIndex = eeAlarm8(p, 143, 90)
+	ee.Alarms.THSWIndex = eeAlarm8(p, 144, 90)
+	ee.Alarms.WindSpeed = eeAlarm8(p, 145, 0)
+	ee.Alarms.WindSpeed10Min = eeAlarm8(p, 146, 0)
+	ee.Alarms.UVIndex = eeAlarmFloat(eeAlarm8(p, 147, 0), 0.1)
+	ee.Alarms.SolarRad = eeAlarm16(p, 165)
+	ee.Alarms.RainRate = eeAlarmFloat(eeAlarm16(p, 167), rainClick)
+	ee.Alarms.Rain15Min = eeAlarmFloat(eeAlarm16(p, 169), rainClick)
+	ee.Alarms.Rain24Hour = eeAlarmFloat(eeAlarm16(p, 171), rainClick)
+	ee.Alarms.RainStorm = eeAlarmFloat(eeAlarm16(p, 173), rainClick)
+	ee.Alarms.ETDay = eeAlarmFloat(eeAlarm8(p, 175, 0), 0.001)
+
 	return nil
 }
+
+// eeAlarmBar decodes a 1-byte barometer trend alarm threshold in
+// thousandths of an inch, returning nil if it's not set.
+func eeAlarmBar(p []byte, i uint) *float64 {
+	v := packet.GetUInt8(p, i)
+	if v == 0 {
+		return nil
+	}
+
+	return eeAlarmFloat(&v, 0.001)
+}
+
+// eeAlarm8 decodes a 1-byte alarm threshold stored with an offset,
+// returning nil if it's not set.
+func eeAlarm8(p []byte, i uint, offset int) *int {
+	v := packet.GetUInt8(p, i)
+	if v == 0xff {
+		return nil
+	}
+	v -= offset
+
+	return &v
+}
+
+// eeAlarm16 decodes a 2-byte alarm threshold, returning nil if it's
+// not set.
+func eeAlarm16(p []byte, i uint) *int {
+	v := packet.GetUInt16(p, i)
+	if v == 0xffff || v == 0x7fff {
+		return nil
+	}
+
+	return &v
+}
+
+// eeAlarmFloat scales an alarm threshold.
+func eeAlarmFloat(v *int, scale float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	f := float64(*v) * scale
+
+	return &f
+}
+
+// EEChange is a setting which differs between two EEPROM configurations.
+type EEChange struct {
+	Name string      `json:"name"` // Setting name, e.g. Stations[1].Type
+	A    interface{} `json:"a"`    // Value in the first, nil if not set
+	B    interface{} `json:"b"`    // Value in the second, nil if not set
+}
+
+// DiffEEPROM compares two EEPROM configurations and returns the settings
+// which differ.
+func DiffEEPROM(a, b EEPROM) (changes []EEChange) {
+	diff(&changes, "", reflect.ValueOf(a), reflect.ValueOf(b))
+
+	return
+}
+
+// diff compares two values of the same type and appends the settings
+// which differ to changes.
+func diff(changes *[]EEChange, name string, a, b reflect.Value) {
+	switch a.Kind() {
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			diff(changes, fmt.Sprintf("%s[%d]", name, i), a.Index(i), b.Index(i))
+		}
+	case reflect.Ptr:
+		if !a.IsNil() && !b.IsNil() {
+			diff(changes, name, a.Elem(), b.Elem())
+		} else if a.IsNil() != b.IsNil() {
+			*changes = append(*changes, EEChange{Name: name, A: elem(a), B: elem(b)})
+		}
+	case reflect.Struct:
+		if t, ok := a.Interface().(time.Time); ok {
+			if !t.Equal(b.Interface().(time.Time)) {
+				*changes = append(*changes, EEChange{Name: name, A: a.Interface(), B: b.Interface()})
+			}
+			return
+		}
+		for i := 0; i < a.NumField(); i++ {
+			f := a.Type().Field(i)
+			if f.PkgPath != "" {
+				// Unexported
+				continue
+			}
+			fname := f.Name
+			if name != "" {
+				fname = name + "." + f.Name
+			}
+			diff(changes, fname, a.Field(i), b.Field(i))
+		}
+	default:
+		if a.Interface() != b.Interface() {
+			*changes = append(*changes, EEChange{Name: name, A: a.Interface(), B: b.Interface()})
+		}
+	}
+}
+
+// elem returns the value a pointer points to or nil if it's nil.
+func elem(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return nil
+	}
+
+	return v.Elem().Interface()
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ebarkie/weatherlink/packet"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -551,4 +552,237 @@ func TestEEPROMUnmarshalBinary(t *testing.T) {
 	a.Equal(-78.8, ee.Lon, "Longitude")
 
 	a.Equal(-5*time.Hour, ee.TimeOffset, "Time GMT offset")
+
+	// Time zone
+	a.Equal(10, ee.TimeZone.Zone, "Time zone")
+	a.Equal("Eastern Time", ee.TimeZone.Name, "Time zone name")
+	a.Equal(-5*time.Hour, ee.TimeZone.Offset, "Time zone offset")
+	a.False(ee.TimeZone.UseOffset, "Time zone custom offset")
+	a.False(ee.TimeZone.DSTManual, "Daylight savings manual")
+
+	// Station list
+	a.Equal(0, ee.Retransmit, "Retransmit ID")
+	a.Equal(EEStation{Active: true, ID: 1, Type: "ISS"}, ee.Stations[0], "Station 1")
+	a.Equal(EEStation{Active: true, ID: 2, Type: "Soil"}, ee.Stations[1], "Station 2")
+	a.Equal("None", ee.Stations[7].Type, "Station 8")
+
+	// Units and setup
+	a.Equal(EEUnits{Bar: "in", Elev: "ft", Rain: "in", Temp: "F", Wind: "mph"}, ee.Units, "Units")
+	a.Equal(EESetup{AM: true, RainCollector: "0.01in", WindCupSize: "Large"}, ee.Setup, "Setup")
+	a.Equal(time.January, ee.RainSeasonStart, "Rain season start")
+	a.False(ee.LogAvgTemp, "Log average temperature")
+
+	// Calibration
+	a.Equal(-3.0, ee.Cal.InTemp, "Inside temperature calibration")
+	a.Equal(0.0, ee.Cal.OutTemp, "Outside temperature calibration")
+	a.Equal(0, ee.Cal.WindDir, "Wind direction calibration")
+
+	// Alarms
+	a.Nil(ee.Alarms.BarRise, "Barometer rise alarm")
+	a.True(ee.Alarms.Time.IsZero(), "Time alarm")
+	a.Nil(ee.Alarms.OutTempHi, "High outside temperature alarm")
+	a.Nil(ee.Alarms.SolarRad, "Solar radiation alarm")
+	a.Nil(ee.Alarms.RainRate, "Rain rate alarm")
+}
+
+func TestEEPROMUnmarshalBinarySettings(t *testing.T) {
+	a := assert.New(t)
+
+	p := append([]byte{}, testEEPROMPackets["std"]...)
+	p[0x16] = 1                   // Custom GMT offset
+	p[0x14], p[0x15] = 0x4a, 0x01 // +3:30
+	p[0x1b], p[0x1c] = 0x83, 0x21 // Temp/Hum through repeater A
+	p[0x29] = 0xff                // knots, mm, m, C (tenth), mb
+	p[0x2b] = 0x5d                // 0.2mm, N, W, large cup, day/month, 24 hour
+	p[0x34] = 0x05                // Outside temperature +0.5
+	p[0x45] = 0xfe                // Outside humidity -2
+	p[0x52] = 30                  // Barometer rise 0.030 in
+	p[0x5b] = 100 + 90            // High outside temperature 100
+	p[0x5c] = 0                   // Low extra temperature 1 -90
+	p[0x8e] = 120 - 10            // Wind chill -10
+	p[0xa7], p[0xa8] = 10, 0      // Rain rate 10 clicks
+	packet.SetCrc(&p)
+
+	ee := EEPROM{}
+	err := ee.UnmarshalBinary(p)
+	a.Nil(err, "UnmarshalBinary EEPROM")
+
+	a.True(ee.TimeZone.UseOffset, "Time zone custom offset")
+	a.Equal(3*time.Hour+30*time.Minute, ee.TimeZone.Offset, "Time zone offset")
+	a.Equal("", ee.TimeZone.Name, "Time zone name")
+
+	temp, hum := 1, 2
+	a.Equal(EEStation{Active: true, HumSensor: &hum, ID: 2, Repeater: "A", TempSensor: &temp, Type: "Temp/Hum"},
+		ee.Stations[1], "Station 2")
+
+	a.Equal(EEUnits{Bar: "mb", Elev: "m", Rain: "mm", Temp: "C", TempTenths: true, Wind: "knots"}, ee.Units, "Units")
+	a.Equal(EESetup{Clock24Hour: true, DayMonth: true, RainCollector: "0.2mm", WindCupSize: "Large"}, ee.Setup, "Setup")
+
+	a.Equal(0.5, ee.Cal.OutTemp, "Outside temperature calibration")
+	a.Equal(-2, ee.Cal.OutHumidity, "Outside humidity calibration")
+
+	if a.NotNil(ee.Alarms.BarRise, "Barometer rise alarm") {
+		a.Equal(0.03, *ee.Alarms.BarRise, "Barometer rise alarm")
+	}
+	if a.NotNil(ee.Alarms.OutTempHi, "High outside temperature alarm") {
+		a.Equal(100, *ee.Alarms.OutTempHi, "High outside temperature alarm")
+	}
+	if a.NotNil(ee.Alarms.ExtraTempLow[0], "Low extra temperature alarm") {
+		a.Equal(-90, *ee.Alarms.ExtraTempLow[0], "Low extra temperature alarm")
+	}
+	if a.NotNil(ee.Alarms.WindChill, "Wind chill alarm") {
+		a.Equal(-10, *ee.Alarms.WindChill, "Wind chill alarm")
+	}
+	if a.NotNil(ee.Alarms.RainRate, "Rain rate alarm") {
+		a.InDelta(10*0.2/25.4, *ee.Alarms.RainRate, 0.00001, "Rain rate alarm")
+	}
+}
+
+func TestEEStationMarshalBinary(t *testing.T) {
+	a := assert.New(t)
+
+	temp, hum := 1, 2
+	p, err := EEStation{HumSensor: &hum, Repeater: "A", TempSensor: &temp, Type: "Temp/Hum"}.MarshalBinary()
+	a.Nil(err, "MarshalBinary station")
+	a.Equal([]byte{0x83, 0x21}, p, "Temp/Hum station")
+
+	p, err = EEStation{Type: "ISS"}.MarshalBinary()
+	a.Nil(err, "MarshalBinary station")
+	a.Equal([]byte{0x00, 0xff}, p, "ISS station")
+
+	_, err = EEStation{Type: "Anemometer"}.MarshalBinary()
+	a.Equal(ErrUnknownStation, err, "Unknown station type")
+	_, err = EEStation{Repeater: "J", Type: "ISS"}.MarshalBinary()
+	a.Equal(ErrUnknownStation, err, "Unknown repeater")
+}
+
+func TestEETimeZoneMarshalBinary(t *testing.T) {
+	a := assert.New(t)
+
+	p, err := EETimeZone{DST: true, Zone: 10}.MarshalBinary()
+	a.Nil(err, "MarshalBinary time zone")
+	a.Equal([]byte{0x0a, 0x00, 0x01, 0x0c, 0xfe, 0x00}, p, "Preset time zone")
+
+	p, err = EETimeZone{Offset: -3*time.Hour - 30*time.Minute, UseOffset: true}.MarshalBinary()
+	a.Nil(err, "MarshalBinary time zone")
+	a.Equal([]byte{0x00, 0x00, 0x00, 0xb6, 0xfe, 0x01}, p, "Custom time zone")
+
+	_, err = EETimeZone{Zone: 47}.MarshalBinary()
+	a.Equal(ErrBadTimeZone, err, "Unknown preset time zone")
+}
+
+func TestEETimeZoneUnmarshalBinary(t *testing.T) {
+	a := assert.New(t)
+
+	var tz EETimeZone
+	a.Nil(tz.UnmarshalBinary([]byte{0x0a, 0x00, 0x01, 0x0c, 0xfe, 0x00}), "UnmarshalBinary time zone")
+	a.Equal(EETimeZone{DST: true, Name: "Eastern Time", Offset: -5 * time.Hour, Zone: 10}, tz, "Preset time zone")
+
+	a.Nil(tz.UnmarshalBinary([]byte{0x00, 0x00, 0x00, 0xb6, 0xfe, 0x01}), "UnmarshalBinary time zone")
+	a.Equal(EETimeZone{Offset: -3*time.Hour - 30*time.Minute, UseOffset: true}, tz, "Custom time zone")
+
+	a.Equal(ErrBadTimeZone, tz.UnmarshalBinary([]byte{0x0a}), "Truncated")
+}
+
+func TestEETimeZoneLocation(t *testing.T) {
+	a := assert.New(t)
+
+	summer := time.Date(2016, time.July, 1, 12, 0, 0, 0, time.UTC)
+	winter := time.Date(2016, time.December, 1, 12, 0, 0, 0, time.UTC)
+
+	loc := EETimeZone{Zone: 10}.Location()
+	a.Equal("America/New_York", loc.String(), "Automatic daylight savings")
+	_, offset := summer.In(loc).Zone()
+	a.Equal(-4*60*60, offset, "Summer offset")
+	_, offset = winter.In(loc).Zone()
+	a.Equal(-5*60*60, offset, "Winter offset")
+
+	loc = EETimeZone{DST: true, DSTManual: true, Name: "Eastern Time", Zone: 10}.Location()
+	_, offset = winter.In(loc).Zone()
+	a.Equal(-4*60*60, offset, "Manual daylight savings on")
+
+	loc = EETimeZone{Offset: -3*time.Hour - 30*time.Minute, UseOffset: true}.Location()
+	a.Equal("GMT-03:30", loc.String(), "Custom offset name")
+	_, offset = summer.In(loc).Zone()
+	a.Equal(-3*60*60-30*60, offset, "Custom offset")
+}
+
+func TestEEPROMMarshalBinary(t *testing.T) {
+	a := assert.New(t)
+
+	ee := EEPROM{}
+	a.Nil(ee.UnmarshalBinary(testEEPROMPackets["std"]), "UnmarshalBinary EEPROM")
+	p, err := ee.MarshalBinary()
+	a.Nil(err, "MarshalBinary EEPROM")
+	a.Equal(testEEPROMPackets["std"][:4096], p, "Unchanged image")
+	ee2 := EEPROM{}
+	a.Nil(ee2.UnmarshalBinary(p), "UnmarshalBinary image without CRC")
+	a.Empty(DiffEEPROM(ee, ee2), "Image without CRC")
+	a.Equal(ErrNotEEPROM, ee2.UnmarshalBinary(p[:4095]), "Short image")
+	bad := append([]byte{}, testEEPROMPackets["std"]...)
+	bad[0] ^= 0xff
+	a.Equal(ErrBadCRC, ee2.UnmarshalBinary(bad), "Bad CRC")
+
+	// Change every group of settings.
+	now := time.Now()
+	click := 0.2 / 25.4
+	temp, hum, hi, rain, solar, uv := 2, 3, 100, 25*click, 1000, 8.5
+	ee.Alarms.OutTempHi = &hi
+	ee.Alarms.RainRate = &rain
+	ee.Alarms.SolarRad = &solar
+	ee.Alarms.Time = time.Date(now.Year(), now.Month(), now.Day(), 14, 30, 0, 0, time.Local)
+	ee.Alarms.UVIndex = &uv
+	ee.ArchivePeriod = 10
+	ee.Cal.ExtraHumidity[3] = -4
+	ee.Cal.InTemp = 1.5
+	ee.Cal.WindDir = -10
+	ee.Elev = 500
+	ee.Lat, ee.Lon = -33.9, 18.4
+	ee.LogAvgTemp = true
+	ee.RainSeasonStart = time.July
+	ee.Retransmit = 3
+	ee.Setup = EESetup{Clock24Hour: true, RainCollector: "0.2mm", WindCupSize: "Other"}
+	ee.Stations[2] = EEStation{Active: true, HumSensor: &hum, ID: 3, TempSensor: &temp, Type: "Temp/Hum"}
+	ee.TimeOffset = time.Hour
+	ee.TimeZone = EETimeZone{Name: timeZones[20].name, Offset: time.Hour, Zone: 20}
+	ee.Units.Wind = "knots"
+	ee.Units.TempTenths = true
+
+	p, err = ee.MarshalBinary()
+	a.Nil(err, "MarshalBinary EEPROM")
+	ee2 = EEPROM{}
+	a.Nil(ee2.UnmarshalBinary(p), "UnmarshalBinary EEPROM")
+	a.Empty(DiffEEPROM(ee, ee2), "Changed settings")
+	a.Equal(testEEPROMPackets["std"][300:4092], p[300:4092], "Undocumented region")
+
+	// Without an image to start from.
+	ee2.raw = nil
+	p, err = ee2.MarshalBinary()
+	a.Nil(err, "MarshalBinary EEPROM")
+	ee3 := EEPROM{}
+	a.Nil(ee3.UnmarshalBinary(p), "UnmarshalBinary EEPROM")
+	a.Empty(DiffEEPROM(ee2, ee3), "Settings without an image")
+
+	ee.Units.Wind = "furlongs/fortnight"
+	_, err = ee.MarshalBinary()
+	a.Equal(ErrUnknownUnit, err, "Unknown unit")
+}
+
+func TestDiffEEPROM(t *testing.T) {
+	a := assert.New(t)
+
+	ee := EEPROM{}
+	a.Nil(ee.UnmarshalBinary(testEEPROMPackets["std"]), "UnmarshalBinary EEPROM")
+	a.Empty(DiffEEPROM(ee, ee), "Same settings")
+
+	ee2 := ee
+	hi := 100
+	ee2.Alarms.OutTempHi = &hi
+	ee2.Lat = 36.0
+	ee2.Stations[1].Type = "Leaf"
+	a.Equal([]EEChange{
+		{Name: "Alarms.OutTempHi", A: nil, B: 100},
+		{Name: "Lat", A: 35.8, B: 36.0},
+		{Name: "Stations[1].Type", A: "Soil", B: "Leaf"},
+	}, DiffEEPROM(ee, ee2), "Changed settings")
 }
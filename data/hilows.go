@@ -433,3 +433,179 @@ func (hl *HiLows) UnmarshalBinary(p []byte) error {
 
 	return nil
 }
+
+// MarshalBinary encodes the data from the HiLows struct into a 438-byte
+// high and lows packet.  Only the time of day of the day record times is
+// encoded.
+func (hl HiLows) MarshalBinary() (p []byte, err error) {
+	p = make([]byte, 438)
+	for i := range p {
+		p[i] = 0xff
+	}
+
+	// Barometer
+	packet.SetPressure(&p, 0, hl.Bar.Day.Low)
+	packet.SetTime16(&p, 12, hl.Bar.Day.LowTime)
+	packet.SetPressure(&p, 2, hl.Bar.Day.Hi)
+	packet.SetTime16(&p, 14, hl.Bar.Day.HiTime)
+	packet.SetPressure(&p, 4, hl.Bar.Month.Low)
+	packet.SetPressure(&p, 6, hl.Bar.Month.Hi)
+	packet.SetPressure(&p, 8, hl.Bar.Year.Low)
+	packet.SetPressure(&p, 10, hl.Bar.Year.Hi)
+
+	// Dew point
+	packet.SetFloat16(&p, 63, hl.DewPoint.Day.Low)
+	packet.SetTime16(&p, 67, hl.DewPoint.Day.LowTime)
+	packet.SetFloat16(&p, 65, hl.DewPoint.Day.Hi)
+	packet.SetTime16(&p, 69, hl.DewPoint.Day.HiTime)
+	packet.SetFloat16(&p, 73, hl.DewPoint.Month.Low)
+	packet.SetFloat16(&p, 71, hl.DewPoint.Month.Hi)
+	packet.SetFloat16(&p, 77, hl.DewPoint.Year.Low)
+	packet.SetFloat16(&p, 75, hl.DewPoint.Year.Hi)
+
+	// Extra humidity and temperatures
+	extraHumidity := func(p *[]byte, i uint, h HiLowHumidity) {
+		packet.SetUInt8(p, 276+i, h.Day.Low)
+		packet.SetTime16(p, 292+i*2, h.Day.LowTime)
+		packet.SetUInt8(p, 284+i, h.Day.Hi)
+		packet.SetTime16(p, 308+i*2, h.Day.HiTime)
+		packet.SetUInt8(p, 332+i, h.Month.Low)
+		packet.SetUInt8(p, 324+i, h.Month.Hi)
+		packet.SetUInt8(p, 348+i, h.Year.Low)
+		packet.SetUInt8(p, 340+i, h.Year.Hi)
+	}
+	extraTemp := func(p *[]byte, i uint, et HiLowExtraTemp) {
+		packet.SetTemp8(p, 126+i, et.Day.Low)
+		packet.SetTime16(p, 156+i*2, et.Day.LowTime)
+		packet.SetTemp8(p, 141+i, et.Day.Hi)
+		packet.SetTime16(p, 186+i*2, et.Day.HiTime)
+		packet.SetTemp8(p, 231+i, et.Month.Low)
+		packet.SetTemp8(p, 216+i, et.Month.Hi)
+		packet.SetTemp8(p, 261+i, et.Year.Low)
+		packet.SetTemp8(p, 246+i, et.Year.Hi)
+	}
+	for i := uint(0); i < 7; i++ {
+		if hl.ExtraHumidity[i] != nil {
+			extraHumidity(&p, 1+i, *hl.ExtraHumidity[i])
+		}
+		if hl.ExtraTemp[i] != nil {
+			extraTemp(&p, i, *hl.ExtraTemp[i])
+		}
+	}
+
+	// Heat index
+	packet.SetFloat16(&p, 87, hl.HeatIndex.Day.Hi)
+	packet.SetTime16(&p, 89, hl.HeatIndex.Day.HiTime)
+	packet.SetFloat16(&p, 91, hl.HeatIndex.Month.Hi)
+	packet.SetFloat16(&p, 93, hl.HeatIndex.Year.Hi)
+
+	// Inside humidity
+	packet.SetUInt8(&p, 38, hl.InHumidity.Day.Low)
+	packet.SetTime16(&p, 41, hl.InHumidity.Day.LowTime)
+	packet.SetUInt8(&p, 37, hl.InHumidity.Day.Hi)
+	packet.SetTime16(&p, 39, hl.InHumidity.Day.HiTime)
+	packet.SetUInt8(&p, 44, hl.InHumidity.Month.Low)
+	packet.SetUInt8(&p, 43, hl.InHumidity.Month.Hi)
+	packet.SetUInt8(&p, 46, hl.InHumidity.Year.Low)
+	packet.SetUInt8(&p, 45, hl.InHumidity.Year.Hi)
+
+	// Inside temperature
+	packet.SetFloat16_10(&p, 23, hl.InTemp.Day.Low)
+	packet.SetTime16(&p, 27, hl.InTemp.Day.LowTime)
+	packet.SetFloat16_10(&p, 21, hl.InTemp.Day.Hi)
+	packet.SetTime16(&p, 25, hl.InTemp.Day.HiTime)
+	packet.SetFloat16_10(&p, 29, hl.InTemp.Month.Low)
+	packet.SetFloat16_10(&p, 31, hl.InTemp.Month.Hi)
+	packet.SetFloat16_10(&p, 33, hl.InTemp.Year.Low)
+	packet.SetFloat16_10(&p, 35, hl.InTemp.Year.Hi)
+
+	// Leaf temperature and wetness
+	for i := uint(0); i < 4; i++ {
+		if hl.LeafTemp[i] != nil {
+			extraTemp(&p, 11+i, *hl.LeafTemp[i])
+		}
+
+		if lw := hl.LeafWetness[i]; lw != nil {
+			packet.SetUInt8(&p, 408+i, lw.Day.Low)
+			packet.SetTime16(&p, 412+i*2, lw.Day.LowTime)
+			packet.SetUInt8(&p, 396+i, lw.Day.Hi)
+			packet.SetTime16(&p, 400+i*2, lw.Day.HiTime)
+			packet.SetUInt8(&p, 420+i, lw.Month.Low)
+			packet.SetUInt8(&p, 424+i, lw.Month.Hi)
+			packet.SetUInt8(&p, 428+i, lw.Year.Low)
+			packet.SetUInt8(&p, 432+i, lw.Year.Hi)
+		}
+	}
+
+	// Outside humidity
+	extraHumidity(&p, 0, hl.OutHumidity)
+
+	// Outside temperature
+	packet.SetFloat16_10(&p, 47, hl.OutTemp.Day.Low)
+	packet.SetTime16(&p, 51, hl.OutTemp.Day.LowTime)
+	packet.SetFloat16_10(&p, 49, hl.OutTemp.Day.Hi)
+	packet.SetTime16(&p, 53, hl.OutTemp.Day.HiTime)
+	packet.SetFloat16_10(&p, 57, hl.OutTemp.Month.Low)
+	packet.SetFloat16_10(&p, 55, hl.OutTemp.Month.Hi)
+	packet.SetFloat16_10(&p, 61, hl.OutTemp.Year.Low)
+	packet.SetFloat16_10(&p, 59, hl.OutTemp.Year.Hi)
+
+	// Rain rate
+	packet.SetRain(&p, 120, hl.RainRate.Hour.Hi)
+	packet.SetRain(&p, 116, hl.RainRate.Day.Hi)
+	packet.SetTime16(&p, 118, hl.RainRate.Day.HiTime)
+	packet.SetRain(&p, 122, hl.RainRate.Month.Hi)
+	packet.SetRain(&p, 124, hl.RainRate.Year.Hi)
+
+	// Soil moisture and temperature
+	for i := uint(0); i < 4; i++ {
+		if sm := hl.SoilMoist[i]; sm != nil {
+			packet.SetUInt8(&p, 368+i, sm.Day.Low)
+			packet.SetTime16(&p, 372+i*2, sm.Day.LowTime)
+			packet.SetUInt8(&p, 356+i, sm.Day.Hi)
+			packet.SetTime16(&p, 360+i*2, sm.Day.HiTime)
+			packet.SetUInt8(&p, 380+i, sm.Month.Low)
+			packet.SetUInt8(&p, 384+i, sm.Month.Hi)
+			packet.SetUInt8(&p, 388+i, sm.Year.Low)
+			packet.SetUInt8(&p, 392+i, sm.Year.Hi)
+		}
+
+		if hl.SoilTemp[i] != nil {
+			extraTemp(&p, 7+i, *hl.SoilTemp[i])
+		}
+	}
+
+	// Solar radiation
+	packet.SetUInt16(&p, 103, hl.SolarRad.Day.Hi)
+	packet.SetTime16(&p, 105, hl.SolarRad.Day.HiTime)
+	packet.SetUInt16(&p, 107, hl.SolarRad.Month.Hi)
+	packet.SetUInt16(&p, 109, hl.SolarRad.Year.Hi)
+
+	// THSW index
+	packet.SetFloat16(&p, 95, hl.THSWIndex.Day.Hi)
+	packet.SetTime16(&p, 97, hl.THSWIndex.Day.HiTime)
+	packet.SetFloat16(&p, 99, hl.THSWIndex.Month.Hi)
+	packet.SetFloat16(&p, 101, hl.THSWIndex.Year.Hi)
+
+	// UltraViolet index
+	packet.SetUVIndex(&p, 111, hl.UVIndex.Day.Hi)
+	packet.SetTime16(&p, 112, hl.UVIndex.Day.HiTime)
+	packet.SetUVIndex(&p, 114, hl.UVIndex.Month.Hi)
+	packet.SetUVIndex(&p, 115, hl.UVIndex.Year.Hi)
+
+	// Wind speed
+	packet.SetMPH8(&p, 16, hl.WindSpeed.Day.Hi)
+	packet.SetTime16(&p, 17, hl.WindSpeed.Day.HiTime)
+	packet.SetMPH8(&p, 19, hl.WindSpeed.Month.Hi)
+	packet.SetMPH8(&p, 20, hl.WindSpeed.Year.Hi)
+
+	// Wind chill
+	packet.SetFloat16(&p, 79, hl.WindChill.Day.Low)
+	packet.SetTime16(&p, 81, hl.WindChill.Day.LowTime)
+	packet.SetFloat16(&p, 83, hl.WindChill.Month.Low)
+	packet.SetFloat16(&p, 85, hl.WindChill.Year.Low)
+
+	packet.SetCrc(&p)
+
+	return
+}
@@ -247,3 +247,20 @@ func TestHiLowsUnmarshalBinary(t *testing.T) {
 	a.Equal(68.0, hl.WindChill.Month.Low, "Wind chill month low")
 	a.Equal(9.0, hl.WindChill.Year.Low, "Wind chill year low")
 }
+
+func TestHiLowsMarshalBinary(t *testing.T) {
+	a := assert.New(t)
+
+	hl := HiLows{}
+	err := hl.UnmarshalBinary(testHiLowsPackets["std"])
+	a.Nil(err, "UnmarshalBinary HiLows")
+
+	p, err := hl.MarshalBinary()
+	a.Nil(err, "MarshalBinary HiLows")
+	a.Equal(438, len(p), "Packet length")
+
+	hl2 := HiLows{}
+	err = hl2.UnmarshalBinary(p)
+	a.Nil(err, "UnmarshalBinary marshaled HiLows")
+	a.Equal(hl, hl2, "Round trip")
+}
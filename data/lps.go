@@ -17,36 +17,80 @@ import (
 // During the protocol loop polling with the LPS command the two
 // versions are interleaved.
 type Loop struct {
-	Bar           LoopBar   `json:"barometer"`
-	Bat           LoopBat   `json:"battery"`
-	DewPoint      float64   `json:"dewPoint"`
-	ET            LoopET    `json:"ET"`
-	ExtraHumidity [7]*int   `json:"extraHumidity,omitempty"`
-	ExtraTemp     [7]*int   `json:"extraTemperature,omitempty"`
-	Forecast      string    `json:"forecast"`
-	HeatIndex     float64   `json:"heatIndex"`
-	Icons         []string  `json:"icons"`
-	InHumidity    int       `json:"insideHumidity"`
-	InTemp        float64   `json:"insideTemperature"`
-	LeafTemp      [4]*int   `json:"leafTemperature,omitempty"`
-	LeafWet       [4]*int   `json:"leafWetness,omitempty"`
-	OutHumidity   int       `json:"outsideHumidity"`
-	OutTemp       float64   `json:"outsideTemperature"`
-	Rain          LoopRain  `json:"rain"`
-	SoilMoist     [4]*int   `json:"soilMoisture,omitempty"`
-	SoilTemp      [4]*int   `json:"soilTemperature,omitempty"`
-	SolarRad      int       `json:"solarRadiation"`
-	Sunrise       time.Time `json:"sunrise,omitempty"`
-	Sunset        time.Time `json:"sunset,omitempty"`
-	THSWIndex     float64   `json:"THSWIndex"`
-	UVIndex       float64   `json:"UVIndex"`
-	Wind          LoopWind  `json:"wind"`
-	WindChill     float64   `json:"windChill"`
+	Alarms        LoopAlarms `json:"alarms"`
+	Bar           LoopBar    `json:"barometer"`
+	Bat           LoopBat    `json:"battery"`
+	DewPoint      float64    `json:"dewPoint"`
+	ET            LoopET     `json:"ET"`
+	ExtraHumidity [7]*int    `json:"extraHumidity,omitempty"`
+	ExtraTemp     [7]*int    `json:"extraTemperature,omitempty"`
+	Forecast      string     `json:"forecast"`
+	HeatIndex     float64    `json:"heatIndex"`
+	Icons         []string   `json:"icons"`
+	InHumidity    int        `json:"insideHumidity"`
+	InTemp        float64    `json:"insideTemperature"`
+	LeafTemp      [4]*int    `json:"leafTemperature,omitempty"`
+	LeafWet       [4]*int    `json:"leafWetness,omitempty"`
+	OutHumidity   int        `json:"outsideHumidity"`
+	OutTemp       float64    `json:"outsideTemperature"`
+	Rain          LoopRain   `json:"rain"`
+	SoilMoist     [4]*int    `json:"soilMoisture,omitempty"`
+	SoilTemp      [4]*int    `json:"soilTemperature,omitempty"`
+	SolarRad      int        `json:"solarRadiation"`
+	Sunrise       time.Time  `json:"sunrise,omitempty"`
+	Sunset        time.Time  `json:"sunset,omitempty"`
+	THSWIndex     float64    `json:"THSWIndex"`
+	UVIndex       float64    `json:"UVIndex"`
+	Wind          LoopWind   `json:"wind"`
+	WindChill     float64    `json:"windChill"`
 
 	LoopType   int `json:"-"`
 	NextArcRec int `json:"-"`
 }
 
+// LoopAlarms is the currently active alarm conditions for a Loop struct.
+// Each is named after the EEAlarms threshold which triggers it.
+type LoopAlarms struct {
+	BarFall          bool    `json:"barometerFall,omitempty"`
+	BarRise          bool    `json:"barometerRise,omitempty"`
+	DewPointHi       bool    `json:"dewPointHi,omitempty"`
+	DewPointLow      bool    `json:"dewPointLow,omitempty"`
+	ETDay            bool    `json:"dayET,omitempty"`
+	ExtraHumidityHi  [7]bool `json:"extraHumidityHi"`
+	ExtraHumidityLow [7]bool `json:"extraHumidityLow"`
+	ExtraTempHi      [7]bool `json:"extraTemperatureHi"`
+	ExtraTempLow     [7]bool `json:"extraTemperatureLow"`
+	HeatIndex        bool    `json:"heatIndex,omitempty"`
+	InHumidityHi     bool    `json:"insideHumidityHi,omitempty"`
+	InHumidityLow    bool    `json:"insideHumidityLow,omitempty"`
+	InTempHi         bool    `json:"insideTemperatureHi,omitempty"`
+	InTempLow        bool    `json:"insideTemperatureLow,omitempty"`
+	LeafTempHi       [4]bool `json:"leafTemperatureHi"`
+	LeafTempLow      [4]bool `json:"leafTemperatureLow"`
+	LeafWetnessHi    [4]bool `json:"leafWetnessHi"`
+	LeafWetnessLow   [4]bool `json:"leafWetnessLow"`
+	OutHumidityHi    bool    `json:"outsideHumidityHi,omitempty"`
+	OutHumidityLow   bool    `json:"outsideHumidityLow,omitempty"`
+	OutTempHi        bool    `json:"outsideTemperatureHi,omitempty"`
+	OutTempLow       bool    `json:"outsideTemperatureLow,omitempty"`
+	Rain15Min        bool    `json:"rain15Min,omitempty"`
+	Rain24Hour       bool    `json:"rain24Hour,omitempty"`
+	RainRate         bool    `json:"rainRate,omitempty"`
+	RainStorm        bool    `json:"rainStorm,omitempty"`
+	SoilMoistHi      [4]bool `json:"soilMoistureHi"`
+	SoilMoistLow     [4]bool `json:"soilMoistureLow"`
+	SoilTempHi       [4]bool `json:"soilTemperatureHi"`
+	SoilTempLow      [4]bool `json:"soilTemperatureLow"`
+	SolarRad         bool    `json:"solarRadiation,omitempty"`
+	THSWIndex        bool    `json:"THSWIndex,omitempty"`
+	Time             bool    `json:"time,omitempty"`
+	UVDose           bool    `json:"UVDose,omitempty"`
+	UVIndex          bool    `json:"UVIndex,omitempty"`
+	WindChill        bool    `json:"windChill,omitempty"`
+	WindSpeed        bool    `json:"windSpeed,omitempty"`
+	WindSpeed10Min   bool    `json:"windSpeed10Min,omitempty"`
+}
+
 // LoopBar is the barometer related readings for a Loop struct.
 type LoopBar struct {
 	Altimeter float64 `json:"altimeter"`
@@ -113,6 +157,7 @@ func (l *Loop) UnmarshalBinary(p []byte) error {
 		return ErrNotLoop
 	case 1:
 		// Loop1
+		l.Alarms = getLoopAlarms(p, 70)
 		l.Bar.SeaLevel = packet.GetPressure(p, 7)
 		l.Bar.Trend = packet.GetBarTrend(p, 3)
 		l.Bat.ConsoleVoltage = packet.GetVoltage(p, 87)
@@ -221,7 +266,11 @@ func (l *Loop) MarshalBinary() (p []byte, err error) {
 	switch l.LoopType {
 	case 1:
 		// Loop1
+		setLoopAlarms(&p, 70, l.Alarms)
 		packet.SetPressure(&p, 7, l.Bar.SeaLevel)
+		packet.SetBarTrend(&p, 3, l.Bar.Trend)
+		packet.SetTransStatus(&p, 86, l.Bat.TransLow)
+		packet.SetVoltage(&p, 87, l.Bat.ConsoleVoltage)
 		packet.SetFloat16(&p, 56, l.ET.Today*1000.0)
 		packet.SetFloat16(&p, 58, l.ET.LastMonth*100.0)
 		packet.SetFloat16(&p, 60, l.ET.LastYear*100.0)
@@ -237,8 +286,22 @@ func (l *Loop) MarshalBinary() (p []byte, err error) {
 				packet.SetTemp8(&p, 18+i, 165)
 			}
 		}
+		packet.SetForecast(&p, 90, l.Forecast)
+		packet.SetForecastIcons(&p, 89, l.Icons)
 		packet.SetUInt8(&p, 11, l.InHumidity)
 		packet.SetFloat16_10(&p, 9, l.InTemp)
+		for i := uint(0); i < 4; i++ {
+			if l.LeafTemp[i] != nil {
+				packet.SetTemp8(&p, 29+i, *l.LeafTemp[i])
+			} else {
+				packet.SetTemp8(&p, 29+i, 165)
+			}
+			if l.LeafWet[i] != nil {
+				packet.SetUInt8(&p, 66+i, *l.LeafWet[i])
+			} else {
+				packet.SetUInt8(&p, 66+i, 255)
+			}
+		}
 		packet.SetUInt8(&p, 33, l.OutHumidity)
 		packet.SetFloat16_10(&p, 12, l.OutTemp)
 		packet.SetRain(&p, 50, l.Rain.Accum.Today)
@@ -246,6 +309,7 @@ func (l *Loop) MarshalBinary() (p []byte, err error) {
 		packet.SetRain(&p, 54, l.Rain.Accum.LastYear)
 		packet.SetRain(&p, 46, l.Rain.Accum.Storm)
 		packet.SetRain(&p, 41, l.Rain.Rate)
+		packet.SetDate16(&p, 48, l.Rain.StormStartDate)
 		for i := uint(0); i < 4; i++ {
 			if l.SoilMoist[i] != nil {
 				packet.SetUInt8(&p, 62+i, *l.SoilMoist[i])
@@ -258,7 +322,13 @@ func (l *Loop) MarshalBinary() (p []byte, err error) {
 				packet.SetTemp8(&p, 25+i, 165)
 			}
 		}
+		packet.SetUInt16(&p, 44, l.SolarRad)
+		packet.SetTime16(&p, 91, l.Sunrise)
+		packet.SetTime16(&p, 93, l.Sunset)
+		packet.SetUVIndex(&p, 43, l.UVIndex)
+		packet.SetUInt16(&p, 16, l.Wind.Cur.Dir)
 		packet.SetMPH8(&p, 14, l.Wind.Cur.Speed)
+		packet.SetMPH8(&p, 15, int(l.Wind.Avg.Last10MinSpeed+0.5))
 
 		packet.SetUInt16(&p, 5, l.NextArcRec)
 
@@ -269,6 +339,7 @@ func (l *Loop) MarshalBinary() (p []byte, err error) {
 		packet.SetPressure(&p, 69, l.Bar.Altimeter)
 		packet.SetPressure(&p, 7, l.Bar.SeaLevel)
 		packet.SetPressure(&p, 65, l.Bar.Station)
+		packet.SetBarTrend(&p, 3, l.Bar.Trend)
 		packet.SetFloat16(&p, 30, l.DewPoint)
 		packet.SetFloat16(&p, 56, l.ET.Today*1000.0)
 		packet.SetFloat16(&p, 35, l.HeatIndex)
@@ -282,7 +353,16 @@ func (l *Loop) MarshalBinary() (p []byte, err error) {
 		packet.SetRain(&p, 50, l.Rain.Accum.Today)
 		packet.SetRain(&p, 46, l.Rain.Accum.Storm)
 		packet.SetRain(&p, 41, l.Rain.Rate)
+		packet.SetUInt16(&p, 44, l.SolarRad)
+		packet.SetFloat16(&p, 39, l.THSWIndex)
+		packet.SetUVIndex(&p, 43, l.UVIndex)
+		packet.SetUInt16(&p, 16, l.Wind.Cur.Dir)
 		packet.SetMPH8(&p, 14, l.Wind.Cur.Speed)
+		packet.SetMPH16(&p, 20, l.Wind.Avg.Last2MinSpeed)
+		packet.SetMPH16(&p, 18, l.Wind.Avg.Last10MinSpeed)
+		packet.SetUInt16(&p, 24, l.Wind.Gust.Last10MinDir)
+		packet.SetMPH16(&p, 22, l.Wind.Gust.Last10MinSpeed)
+		packet.SetFloat16(&p, 37, l.WindChill)
 
 		// Unused fields.
 		for _, i := range []uint{5, 26, 28, 83, 85, 87, 89, 91, 93} {
@@ -322,3 +402,73 @@ func setLoopType(p *[]byte, t int) {
 
 	(*p)[4] = byte(t - 1)
 }
+
+// loopAlarmBytes is the number of alarm bytes in a loop 1 packet.
+const loopAlarmBytes = 16
+
+// bits returns the alarm condition of each bit in loop 1 alarm byte n,
+// counting from the first alarm byte.  Unused bits are nil.
+func (la *LoopAlarms) bits(n int) []*bool {
+	switch {
+	case n == 0:
+		// Inside
+		return []*bool{&la.BarFall, &la.BarRise, &la.InTempLow, &la.InTempHi,
+			&la.InHumidityLow, &la.InHumidityHi, &la.Time}
+	case n == 1:
+		// Rain
+		return []*bool{&la.RainRate, &la.Rain15Min, &la.Rain24Hour,
+			&la.RainStorm, &la.ETDay}
+	case n == 2:
+		// Outside
+		return []*bool{&la.OutTempLow, &la.OutTempHi, &la.WindSpeed, &la.WindSpeed10Min,
+			&la.DewPointLow, &la.DewPointHi, &la.HeatIndex, &la.WindChill}
+	case n == 3:
+		// Outside, byte 2.  Bit 4 is whether the UV dose alarm is
+		// enabled rather than an alarm.
+		return []*bool{&la.THSWIndex, &la.SolarRad, &la.UVIndex, &la.UVDose}
+	case n == 4:
+		// Outside humidity
+		return []*bool{nil, nil, &la.OutHumidityLow, &la.OutHumidityHi}
+	case n < 12:
+		// Extra temperature/humidity, one byte per sensor.
+		s := n - 5
+		return []*bool{&la.ExtraTempLow[s], &la.ExtraTempHi[s],
+			&la.ExtraHumidityLow[s], &la.ExtraHumidityHi[s]}
+	default:
+		// Soil and leaf, one byte per sensor.
+		s := n - 12
+		return []*bool{&la.LeafWetnessLow[s], &la.LeafWetnessHi[s],
+			&la.SoilMoistLow[s], &la.SoilMoistHi[s],
+			&la.LeafTempLow[s], &la.LeafTempHi[s],
+			&la.SoilTempLow[s], &la.SoilTempHi[s]}
+	}
+}
+
+// getLoopAlarms gets the active alarm conditions from the loop 1 alarm
+// bytes starting at the specified index.
+func getLoopAlarms(p []byte, i uint) (la LoopAlarms) {
+	for n := 0; n < loopAlarmBytes; n++ {
+		v := packet.GetUInt8(p, i+uint(n))
+		for bit, b := range la.bits(n) {
+			if b != nil {
+				*b = v&(1<<uint(bit)) != 0
+			}
+		}
+	}
+
+	return
+}
+
+// setLoopAlarms sets the loop 1 alarm bytes starting at the specified
+// index from the active alarm conditions.
+func setLoopAlarms(p *[]byte, i uint, la LoopAlarms) {
+	for n := 0; n < loopAlarmBytes; n++ {
+		var v int
+		for bit, b := range la.bits(n) {
+			if b != nil && *b {
+				v |= 1 << uint(bit)
+			}
+		}
+		packet.SetUInt8(p, i+uint(n), v)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ebarkie/weatherlink/packet"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -119,6 +120,7 @@ func TestLoopUnmarshalBinaryLoop1Rain(t *testing.T) {
 	err := l.UnmarshalBinary(testLoopPackets["1Rain"])
 	a.Nil(err, "UnmarshalBinary Loop(1)")
 
+	a.Equal(LoopAlarms{}, l.Alarms, "Alarms")
 	a.Equal(29.982, l.Bar.SeaLevel, "Barometer sea level")
 	a.Equal("Steady", l.Bar.Trend, "Barometer trend")
 	a.Equal(4.763671875, l.Bat.ConsoleVoltage, "Console battery voltage")
@@ -209,16 +211,57 @@ func TestLoopUnmarshalBinaryLoop2NegTemp(t *testing.T) {
 	a.Equal(-1.0, l.DewPoint, "Dew point")
 }
 
+func TestLoopUnmarshalBinaryBarTrend(t *testing.T) {
+	a := assert.New(t)
+
+	trends := map[byte]string{
+		0xc4: "Falling Rapidly",
+		0xec: "Falling Slowly",
+		0x00: "Steady",
+		0x14: "Rising Slowly",
+		0x3c: "Rising Rapidly",
+	}
+	for b, trend := range trends {
+		p := append([]byte(nil), testLoopPackets["2NoRain"]...)
+		p[3] = b
+		packet.SetCrc(&p)
+
+		l := Loop{}
+		err := l.UnmarshalBinary(p)
+		a.Nil(err, "UnmarshalBinary Loop(2)")
+		a.Equal(trend, l.Bar.Trend, fmt.Sprintf("Barometer trend 0x%02x", b))
+	}
+}
+
+func TestLoopMarshalBinaryVoltage(t *testing.T) {
+	a := assert.New(t)
+
+	l := Loop{LoopType: 1}
+	l.Bat.ConsoleVoltage = 4.763671875
+	p, err := l.MarshalBinary()
+	a.Nil(err, "MarshalBinary Loop(1)")
+	a.Equal(testLoopPackets["1Rain"][87:89], p[87:89], "Console battery voltage")
+}
+
 func TestLoopMarshalBinary(t *testing.T) {
 	a := assert.New(t)
 
 	li := Loop{}
+	li.Alarms = LoopAlarms{RainRate: true, RainStorm: true, OutTempHi: true,
+		OutHumidityHi: true, UVDose: true}
+	li.Alarms.ExtraTempLow[1] = true
+	li.Alarms.SoilTempHi[3] = true
 	li.Bar.Altimeter = 30.034
 	li.Bar.SeaLevel = 30.012
 	li.Bar.Station = 29.589
+	li.Bar.Trend = "Falling Slowly"
+	li.Bat.ConsoleVoltage = 4.6875
+	li.Bat.TransLow = []int{2, 5}
 	li.DewPoint = 69.0
 	li.ET.Today = 0.014
+	li.Forecast = "Mostly clear and cooler."
 	li.HeatIndex = 80.0
+	li.Icons = []string{"Rain", "Cloud"}
 	li.InHumidity = 39
 	li.InTemp = 78.9
 	li.OutHumidity = 73
@@ -231,7 +274,19 @@ func TestLoopMarshalBinary(t *testing.T) {
 	li.Rain.Accum.LastMonth = 30.0
 	li.Rain.Accum.LastYear = 36.5
 	li.Rain.Accum.Storm = 3.21
+	li.Rain.StormStartDate = time.Date(2016, time.June, 19, 0, 0, 0, 0, time.Local)
+	li.SolarRad = 321
+	li.Sunrise = time.Date(0, 1, 1, 6, 12, 0, 0, time.Local)
+	li.Sunset = time.Date(0, 1, 1, 20, 34, 0, 0, time.Local)
+	li.THSWIndex = 85.0
+	li.UVIndex = 4.5
+	li.Wind.Avg.Last2MinSpeed = 4.2
+	li.Wind.Avg.Last10MinSpeed = 3.7
+	li.Wind.Cur.Dir = 275
 	li.Wind.Cur.Speed = 123
+	li.Wind.Gust.Last10MinDir = 280
+	li.Wind.Gust.Last10MinSpeed = 15.0
+	li.WindChill = 76.0
 
 	lo := Loop{}
 	for t := 1; t < 3; t++ {
@@ -241,12 +296,23 @@ func TestLoopMarshalBinary(t *testing.T) {
 		lo.UnmarshalBinary(p)
 	}
 
+	a.Equal(li.Alarms, lo.Alarms, "Alarms")
+	a.True(lo.Alarms.RainRate, "Rain rate alarm")
+	a.False(lo.Alarms.OutTempLow, "Low outside temperature alarm")
+	p, _ := (&Loop{LoopType: 1, Alarms: li.Alarms}).MarshalBinary()
+	a.Equal([]byte{0x00, 0x09, 0x02, 0x08, 0x08, 0x00, 0x01}, p[70:77], "Alarm bytes")
+	a.Equal(byte(0x80), p[85], "Soil and leaf 4 alarm byte")
 	a.Equal(30.034, lo.Bar.Altimeter, "Barometer altimeter")
 	a.Equal(30.012, lo.Bar.SeaLevel, "Barometer sea level")
 	a.Equal(29.589, lo.Bar.Station, "Barometer station")
+	a.Equal("Falling Slowly", lo.Bar.Trend, "Barometer trend")
+	a.Equal(4.6875, lo.Bat.ConsoleVoltage, "Console battery voltage")
+	a.Equal([]int{2, 5}, lo.Bat.TransLow, "Transmitter batteries low")
 	a.Equal(69.0, lo.DewPoint, "Dew point")
 	a.Equal(0.014, lo.ET.Today, "ET today")
+	a.Equal("Mostly clear and cooler.", lo.Forecast, "Forecast")
 	a.Equal(80.0, lo.HeatIndex, "Heat index")
+	a.Equal([]string{"Rain", "Cloud"}, lo.Icons, "Forecast icons")
 	a.Equal(39, lo.InHumidity, "Inside humidity")
 	a.Equal(78.9, lo.InTemp, "Inside temperature")
 	a.Equal(73, lo.OutHumidity, "Outside humidity")
@@ -259,7 +325,19 @@ func TestLoopMarshalBinary(t *testing.T) {
 	a.Equal(30.0, lo.Rain.Accum.LastMonth, "Rain accumulation this month")
 	a.Equal(36.5, lo.Rain.Accum.LastYear, "Rain accumulation this year")
 	a.Equal(3.21, lo.Rain.Accum.Storm, "Rain accumulation this storm")
+	a.Equal(li.Rain.StormStartDate, lo.Rain.StormStartDate, "Storm start date")
+	a.Equal(321, lo.SolarRad, "Solar radiation")
+	a.Equal("06:12", lo.Sunrise.Format("15:04"), "Sunrise")
+	a.Equal("20:34", lo.Sunset.Format("15:04"), "Sunset")
+	a.Equal(85.0, lo.THSWIndex, "THSW index")
+	a.Equal(4.5, lo.UVIndex, "UV index")
+	a.Equal(4.2, lo.Wind.Avg.Last2MinSpeed, "Wind average last 2 minutes")
+	a.Equal(3.7, lo.Wind.Avg.Last10MinSpeed, "Wind average last 10 minutes")
+	a.Equal(275, lo.Wind.Cur.Dir, "Wind direction")
 	a.Equal(123, lo.Wind.Cur.Speed, "Wind speed")
+	a.Equal(280, lo.Wind.Gust.Last10MinDir, "Wind gust direction")
+	a.Equal(15.0, lo.Wind.Gust.Last10MinSpeed, "Wind gust speed")
+	a.Equal(76.0, lo.WindChill, "Wind chill")
 }
 
 func TestLoopMarshalBinaryLoop2NegTemp(t *testing.T) {
@@ -0,0 +1,89 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package data
+
+// Packet coding logic for the RXCHECK and RECEIVERS commands.
+//
+// Refer to Vantage Pro™, Vantage Pro2™ and Vantage Vue™ Serial
+// Communication Reference Manual, section VIII. Command Summary,
+// subsection 1. Testing Commands.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RxStats is the console reception diagnostics.  All values are counted
+// since midnight or since the diagnostics were last cleared.
+type RxStats struct {
+	Received int `json:"received"`  // Total packets received
+	Missed   int `json:"missed"`    // Total packets missed
+	Resyncs  int `json:"resyncs"`   // Number of resynchronizations
+	MaxInRow int `json:"maxInARow"` // Largest number of packets received in a row
+	CRCErrs  int `json:"crcErrors"` // Number of CRC errors detected
+}
+
+// MarshalText encodes the reception diagnostics into the line of an
+// RXCHECK response.
+func (rs RxStats) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d %d %d %d %d\n\r",
+		rs.Received, rs.Missed, rs.Resyncs, rs.MaxInRow, rs.CRCErrs)), nil
+}
+
+// UnmarshalText decodes the line of an RXCHECK response into the RxStats
+// struct.
+func (rs *RxStats) UnmarshalText(p []byte) error {
+	f := strings.Fields(strings.TrimRight(string(p), "\n\r"))
+	if len(f) != 5 {
+		return ErrNotRxCheck
+	}
+
+	var v [5]int
+	for i := range f {
+		var err error
+		if v[i], err = strconv.Atoi(f[i]); err != nil {
+			return ErrNotRxCheck
+		}
+	}
+	rs.Received, rs.Missed, rs.Resyncs, rs.MaxInRow, rs.CRCErrs = v[0], v[1], v[2], v[3], v[4]
+
+	return nil
+}
+
+// Receivers is the transmitter IDs the console receives, in ascending
+// order.
+type Receivers []int
+
+// MarshalBinary encodes the transmitter IDs into the 1-byte bitmap of a
+// RECEIVERS response.
+func (r Receivers) MarshalBinary() ([]byte, error) {
+	var b byte
+	for _, id := range r {
+		if id < 1 || id > 8 {
+			return []byte{0}, ErrUnknownStation
+		}
+		b |= 1 << uint(id-1)
+	}
+
+	return []byte{b}, nil
+}
+
+// UnmarshalBinary decodes the 1-byte bitmap of a RECEIVERS response into
+// the Receivers slice.  Bit 0 is transmitter ID 1.
+func (r *Receivers) UnmarshalBinary(p []byte) error {
+	if len(p) != 1 {
+		return ErrNotReceivers
+	}
+
+	*r = Receivers{}
+	for id := 1; id <= 8; id++ {
+		if p[0]&(1<<uint(id-1)) != 0 {
+			*r = append(*r, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRxStatsMarshalText(t *testing.T) {
+	a := assert.New(t)
+
+	rs := RxStats{Received: 21629, Missed: 15, MaxInRow: 3204, CRCErrs: 128}
+	p, err := rs.MarshalText()
+	a.Nil(err, "MarshalText RxStats")
+
+	a.Equal([]byte("21629 15 0 3204 128\n\r"), p, "Reception diagnostics")
+}
+
+func TestRxStatsUnmarshalText(t *testing.T) {
+	a := assert.New(t)
+
+	var rs RxStats
+	err := rs.UnmarshalText([]byte("21629 15 0 3204 128\n\r"))
+	a.Nil(err, "UnmarshalText RxStats")
+
+	a.Equal(21629, rs.Received, "Packets received")
+	a.Equal(15, rs.Missed, "Packets missed")
+	a.Equal(0, rs.Resyncs, "Resynchronizations")
+	a.Equal(3204, rs.MaxInRow, "Max in a row")
+	a.Equal(128, rs.CRCErrs, "CRC errors")
+
+	a.Equal(ErrNotRxCheck, rs.UnmarshalText([]byte("21629 15\n\r")), "Truncated")
+	a.Equal(ErrNotRxCheck, rs.UnmarshalText([]byte("21629 15 0 x 128\n\r")), "Not a number")
+}
+
+func TestReceiversMarshalBinary(t *testing.T) {
+	a := assert.New(t)
+
+	p, err := Receivers{1, 3, 8}.MarshalBinary()
+	a.Nil(err, "MarshalBinary Receivers")
+	a.Equal([]byte{0x85}, p, "Receivers bitmap")
+
+	_, err = Receivers{9}.MarshalBinary()
+	a.Equal(ErrUnknownStation, err, "Transmitter ID out of range")
+}
+
+func TestReceiversUnmarshalBinary(t *testing.T) {
+	a := assert.New(t)
+
+	var r Receivers
+	err := r.UnmarshalBinary([]byte{0x85})
+	a.Nil(err, "UnmarshalBinary Receivers")
+	a.Equal(Receivers{1, 3, 8}, r, "Transmitter IDs")
+
+	a.Nil(r.UnmarshalBinary([]byte{0}))
+	a.Equal(Receivers{}, r, "Nothing received")
+
+	a.Equal(ErrNotReceivers, r.UnmarshalBinary([]byte{}), "Empty")
+}
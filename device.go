@@ -0,0 +1,225 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebarkie/weatherlink/internal/device"
+)
+
+// Device is an interface for the protocol to use to perform basic I/O
+// operations with different Weatherlink devices.
+type Device interface {
+	io.ReadWriteCloser
+	Dial(addr string) error
+	Flush() error
+	ReadFull(buf []byte) (n int, err error)
+}
+
+// DeviceFunc creates a Device for a dial URL.  It returns the device
+// and the address to dial it with.
+type DeviceFunc func(u *url.URL) (d Device, addr string, err error)
+
+// devices is the registry of device URL schemes.
+var devices = struct {
+	sync.RWMutex
+	m map[string]DeviceFunc
+}{m: map[string]DeviceFunc{}}
+
+// RegisterDevice registers a device for a URL scheme so it can be
+// used by Dial.  Registering an existing scheme replaces it.
+func RegisterDevice(scheme string, f DeviceFunc) {
+	devices.Lock()
+	defer devices.Unlock()
+
+	devices.m[scheme] = f
+}
+
+func init() {
+	RegisterDevice("replay", func(u *url.URL) (Device, string, error) {
+		f, err := os.Open(u.Host + u.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		defer f.Close()
+
+		d, err := NewReplay(f)
+		return d, "", err
+	})
+	RegisterDevice("serial", newSerial)
+	RegisterDevice("sim", newSim)
+	RegisterDevice("tcp", newIP)
+}
+
+// Default device read timeout.
+const devTimeout = 6 * time.Second
+
+// timeout returns the timeout query parameter or the default.
+func timeout(q url.Values) (time.Duration, error) {
+	v := q.Get("timeout")
+	if v == "" {
+		return devTimeout, nil
+	}
+
+	t, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: timeout %q", ErrInvalidArg, v)
+	}
+
+	return t, nil
+}
+
+// newIP creates a Weatherlink IP device.  The query parameters are:
+//
+//	timeout  Read timeout, e.g. 6s
+func newIP(u *url.URL) (Device, string, error) {
+	t, err := timeout(u.Query())
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &device.IP{Timeout: t}, u.Host, nil
+}
+
+// newSim creates a simulated device.  The query parameters are:
+//
+//	badack   Rate at which ACKs are garbage, e.g. 0.1
+//	crc      Rate at which packet CRCs are corrupted
+//	drop     Rate at which a byte is dropped from responses
+//	stall    Rate at which reads stall until the timeout
+//	timeout  Read timeout, e.g. 6s
+//	wakeup   Rate at which spurious wakeup responses are sent
+func newSim(u *url.URL) (Device, string, error) {
+	q := u.Query()
+
+	t, err := timeout(q)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &device.Sim{Timeout: t}
+
+	for k, rate := range map[string]*float64{
+		"badack": &s.Faults.BadAck,
+		"crc":    &s.Faults.CRC,
+		"drop":   &s.Faults.Drop,
+		"stall":  &s.Faults.Stall,
+		"wakeup": &s.Faults.Wakeup,
+	} {
+		v := q.Get(k)
+		if v == "" {
+			continue
+		}
+		*rate, err = strconv.ParseFloat(v, 64)
+		if err != nil || *rate < 0 || *rate > 1 {
+			return nil, "", fmt.Errorf("%w: %s %q", ErrInvalidArg, k, v)
+		}
+	}
+
+	return s, "", nil
+}
+
+// newSerial creates a serial or USB device.  The query parameters are:
+//
+//	baud     Baud rate, e.g. 19200
+//	flow     Flow control: none, software, or hardware
+//	timeout  Read timeout, e.g. 6s
+func newSerial(u *url.URL) (Device, string, error) {
+	q := u.Query()
+
+	t, err := timeout(q)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &device.Serial{Timeout: t}
+
+	if v := q.Get("baud"); v != "" {
+		s.Baud, err = strconv.Atoi(v)
+		if err != nil || !validBaud(s.Baud) {
+			return nil, "", fmt.Errorf("%w: baud %q", ErrInvalidArg, v)
+		}
+	}
+
+	switch v := q.Get("flow"); v {
+	case "", "none":
+		s.FlowControl = device.NoFlowControl
+	case "software", "xonxoff":
+		s.FlowControl = device.SoftwareFlowControl
+	case "hardware", "rtscts":
+		s.FlowControl = device.HardwareFlowControl
+	default:
+		return nil, "", fmt.Errorf("%w: flow %q", ErrInvalidArg, v)
+	}
+
+	return s, u.Path, nil
+}
+
+// newDevice creates the device for a dial address.  Addresses without a
+// URL scheme are treated as they always have been: /dev/null is the
+// simulator, anything else under /dev/ is a serial port, and everything
+// else is a TCP/IP host:port.  Both may include a query string, e.g.
+// /dev/ttyUSB0?baud=2400.
+func newDevice(addr string) (Device, string, error) {
+	if !strings.Contains(addr, "://") {
+		switch {
+		case addr == "/dev/null":
+			addr = "sim://"
+		case strings.HasPrefix(addr, "/dev/"):
+			addr = "serial://" + addr
+		default:
+			addr = "tcp://" + addr
+		}
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, "", err
+	}
+
+	devices.RLock()
+	f, ok := devices.m[u.Scheme]
+	devices.RUnlock()
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnknownDevice, u.Scheme)
+	}
+
+	return f(u)
+}
+
+// Record wraps a device so every operation with it is recorded, with
+// timestamps, to w.  The recording can be played back with NewReplay.
+func Record(d Device, w io.Writer) Device {
+	return device.NewRecorder(d, w)
+}
+
+// DialRecord is like Dial but records the session to w.
+func DialRecord(addr string, w io.Writer) (Conn, error) {
+	d, devAddr, err := newDevice(addr)
+	if err != nil {
+		return Conn{}, err
+	}
+
+	return dial(addr, devAddr, Record(d, w))
+}
+
+// NewReplay returns a device which plays back a session recorded by
+// Record.  Writes must match the recording and reads return what was
+// recorded.  Recordings can also be played back by dialing a
+// replay://path address.
+func NewReplay(r io.Reader) (Device, error) {
+	d, err := device.NewReplay(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
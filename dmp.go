@@ -5,14 +5,64 @@
 package weatherlink
 
 import (
+	"context"
 	"encoding/hex"
+	"fmt"
 	"time"
 
 	"github.com/ebarkie/weatherlink/data"
 )
 
-// Archive interval.
-const archInt = 5 * time.Minute // XXX Read from EEPROM.
+// defaultArcPeriod is the archive period used until it's read from the
+// EEPROM.  It's the factory default.
+const defaultArcPeriod = 5 * time.Minute
+
+// arcPages is the number of pages of archive memory.
+const arcPages = 512
+
+// Download protocol bytes.
+const (
+	dmpNak = 0x15 // Not acknowledge
+	dmpEsc = 0x1b // Escape
+)
+
+// DmpProgress is the progress of an archive download.
+type DmpProgress struct {
+	Pages   int // Pages downloaded
+	Total   int // Total pages to download
+	Records int // Records sent to the event channel
+}
+
+// ProgressFunc is called with the progress of an archive download after
+// each page.
+type ProgressFunc func(DmpProgress)
+
+// DmpToken is how far an archive download got.  The token of an
+// interrupted download resumes it with ResumeDmps.
+type DmpToken struct {
+	Full    bool      // Full download of every page (DMP) rather than DMPAFT
+	Page    int       // Pages downloaded
+	Records int       // Records sent to the event channel
+	LastRec time.Time // Time of the last record sent
+}
+
+// DmpError is the error returned when an archive download is interrupted
+// after it started.
+type DmpError struct {
+	Token DmpToken // Where to resume the download from
+	Err   error    // Why it was interrupted
+}
+
+// Error returns why the download was interrupted and where.
+func (e *DmpError) Error() string {
+	return fmt.Sprintf("archive download interrupted at page %d after %d records: %s",
+		e.Token.Page, e.Token.Records, e.Err.Error())
+}
+
+// Unwrap returns why the download was interrupted.
+func (e *DmpError) Unwrap() error {
+	return e.Err
+}
 
 // GetDmps downloads all archive records *after* lastRec and sends
 // them to the event channel ordered from oldest to newest. It
@@ -20,35 +70,100 @@ const archInt = 5 * time.Minute // XXX Read from EEPROM.
 //
 // If lastRec does not match an existing archive timestamp (which is the case if
 // left uninitialized) then all records in memory are returned.
-func (c Conn) GetDmps(ec chan<- interface{}, lastRec time.Time) (newLastRec time.Time, err error) {
-	const (
-		nak = 0x15 // Not acknowledge
-		esc = 0x1b // Escape
-	)
+//
+// Records only have the console's local time, in the console time zone, so
+// their times are resolved in order and sent in UTC.  This keeps them in
+// order when the same local times are written again because the clock was
+// set back, such as when daylight savings ends.
+//
+// If the download is interrupted after it starts the error is a *DmpError
+// whose token resumes it.
+func (c Conn) GetDmps(ec chan<- interface{}, lastRec time.Time) (time.Time, error) {
+	return c.GetDmpsContext(c.brokerContext(), ec, lastRec)
+}
 
-	Debug.Printf("Retrieving archive records since %s", lastRec)
+// GetDmpsContext is like GetDmps but aborts the download when the context
+// is done.
+func (c Conn) GetDmpsContext(ctx context.Context, ec chan<- interface{}, lastRec time.Time) (time.Time, error) {
+	tok, err := c.dmpAft(ctx, ec, DmpToken{LastRec: lastRec}, nil)
 
-	// If for some reason we return on error before any records are read
-	// it's safer to at least return the original lastRec instead of
-	// a zeroed time.
-	newLastRec = lastRec
+	return tok.LastRec, err
+}
+
+// DumpArchive downloads every page of the archive memory, regardless of
+// the record timestamps, and sends the records to the event channel.  It
+// returns the number of records sent.  This recovers the whole archive
+// even when the console clock has jumped and DMPAFT can't find where to
+// start.
+//
+// Records are sent in archive memory order, which once the memory has
+// filled up and wrapped isn't oldest to newest.  Unwritten records are
+// skipped.  If progress isn't nil it's called after each page.
+//
+// If the download is interrupted after it starts the error is a *DmpError
+// whose token resumes it.
+func (c Conn) DumpArchive(ec chan<- interface{}, progress ProgressFunc) (int, error) {
+	return c.DumpArchiveContext(c.brokerContext(), ec, progress)
+}
+
+// DumpArchiveContext is like DumpArchive but aborts the download when the
+// context is done.
+func (c Conn) DumpArchiveContext(ctx context.Context, ec chan<- interface{}, progress ProgressFunc) (int, error) {
+	tok, err := c.dmpAll(ctx, ec, DmpToken{Full: true}, progress)
+
+	return tok.Records, err
+}
+
+// ResumeDmps resumes an interrupted archive download from the token of
+// its DmpError and returns the token of the resumed download.  Records
+// which were already sent aren't sent again.
+//
+// A DMPAFT download continues with the records after the last one sent,
+// which starts at the page it was interrupted at.  The console always
+// starts a full download at the first page, so those pages are read again
+// but discarded.  Archiving should be stopped during a full download that
+// may need resuming so the memory doesn't change in the meantime.
+func (c Conn) ResumeDmps(ec chan<- interface{}, tok DmpToken, progress ProgressFunc) (DmpToken, error) {
+	return c.ResumeDmpsContext(c.brokerContext(), ec, tok, progress)
+}
+
+// ResumeDmpsContext is like ResumeDmps but aborts the download when the
+// context is done.
+func (c Conn) ResumeDmpsContext(ctx context.Context, ec chan<- interface{}, tok DmpToken, progress ProgressFunc) (DmpToken, error) {
+	if tok.Full {
+		return c.dmpAll(ctx, ec, tok, progress)
+	}
+
+	return c.dmpAft(ctx, ec, tok, progress)
+}
+
+// dmpAft downloads the archive records after the token's last record with
+// DMPAFT.  The token counts continue from the one given.
+func (c Conn) dmpAft(ctx context.Context, ec chan<- interface{}, tok DmpToken, progress ProgressFunc) (DmpToken, error) {
+	lastRec := tok.LastRec
+	Debug.Printf("Retrieving archive records since %s", lastRec)
 
 	// Setup download.
-	_, err = c.writeCmd([]byte("DMPAFT\n"), []byte{ack}, 0)
+	_, err := c.writeCmd(ctx, []byte("DMPAFT\n"), []byte{ack}, 0)
 	if err != nil {
 		Error.Printf("DMPAFT command error: %s, aborting", err.Error())
-		return
+		return tok, err
+	}
+	// The console matches the time of a record as it reads in the console
+	// time zone.
+	if !lastRec.IsZero() {
+		lastRec = lastRec.In(c.ConsLocation())
 	}
 	var p []byte
 	p, err = data.DmpAft(lastRec).MarshalBinary()
 	if err != nil {
 		Error.Printf("DmpAft marshal error: %s, aborting", err.Error())
-		return
+		return tok, err
 	}
-	p, err = c.writeCmd(p, []byte{ack}, 6)
+	p, err = c.writeCmd(ctx, p, []byte{ack}, 6)
 	if err != nil {
 		Error.Printf("Dmp metadata read error: %s, aborting", err.Error())
-		return
+		return tok, err
 	}
 
 	// The response tells us the number of pages we need to download
@@ -59,69 +174,206 @@ func (c Conn) GetDmps(ec chan<- interface{}, lastRec time.Time) (newLastRec time
 	if err != nil {
 		// Most likely a CRC error so cancel gracefully.
 		Error.Printf("Dmp metadata decode error: %s, aborting", err.Error())
-		c.d.Write([]byte{esc})
-		return
+		c.d.Write([]byte{dmpEsc})
+		return tok, err
 	}
 	// If numPages is 0 then it means there's nothing newer than what
 	// we have so we're done.
 	if dm.Pages == 0 {
 		Debug.Println("No newer archive records")
-		return
+		return tok, nil
 	}
 
 	// Start download.
 	// ACK to begin and then loop through all pages we were told are
-	// available.  There are 5 records per page.
+	// available.  There are 5 records per page.  Pages already
+	// downloaded before resuming count towards the progress.
 	Debug.Printf("Starting %d page dmp download", dm.Pages)
 	c.d.Write([]byte{ack})
-	p = make([]byte, 267)
-	for pageNum := 0; pageNum < dm.Pages; pageNum++ {
+	startPage, startRecs := tok.Page, tok.Records
+	err = c.dmpPages(ctx, dm.Pages, func(pageNum int, d data.Dmp) error {
+		for recordNum := 0; recordNum < len(d); recordNum++ {
+			// On the first page skip anything before the offset
+			// given during the download setup.
+			//
+			// On the last page, after reading at least one
+			// record bail out as soon as we hit one where the
+			// date is older than the previous record.
+			if pageNum == 0 && recordNum < dm.FirstPageOffset {
+				continue
+			}
+			d[recordNum].Timestamp = data.ArcTime(d[recordNum].Timestamp, tok.LastRec)
+			if pageNum == dm.Pages-1 &&
+				tok.Records > startRecs &&
+				tok.LastRec.After(d[recordNum].Timestamp) {
+				break
+			}
+
+			select {
+			case ec <- d[recordNum]:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			tok.Records++
+			tok.LastRec = d[recordNum].Timestamp
+			Info.Printf("Retrieved archive record for %s", d[recordNum].Timestamp)
+		}
+		tok.Page = startPage + pageNum + 1
+
+		return c.progress(ctx, ec, progress, DmpProgress{
+			Pages:   tok.Page,
+			Total:   startPage + dm.Pages,
+			Records: tok.Records,
+		})
+	})
+	if err != nil {
+		err = &DmpError{Token: tok, Err: err}
+	}
+
+	return tok, err
+}
+
+// dmpAll downloads every page of the archive memory with DMP.  Records
+// the token says were already sent are skipped.
+func (c Conn) dmpAll(ctx context.Context, ec chan<- interface{}, tok DmpToken, progress ProgressFunc) (DmpToken, error) {
+	Debug.Println("Retrieving all archive records")
+
+	// The console starts sending pages as soon as it acknowledges the
+	// command.
+	_, err := c.writeCmd(ctx, []byte("DMP\n"), []byte{ack}, 0)
+	if err != nil {
+		Error.Printf("DMP command error: %s, aborting", err.Error())
+		return tok, err
+	}
+
+	Debug.Printf("Starting %d page dmp download", arcPages)
+	skip := tok.Records
+	var prev time.Time
+	err = c.dmpPages(ctx, arcPages, func(pageNum int, d data.Dmp) error {
+		for _, a := range d {
+			if a.Timestamp.IsZero() {
+				// Unwritten.
+				continue
+			}
+			a.Timestamp = data.ArcTime(a.Timestamp, prev)
+			prev = a.Timestamp
+			if skip > 0 {
+				// Already sent before resuming.
+				skip--
+				continue
+			}
+
+			select {
+			case ec <- a:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			tok.Records++
+			tok.LastRec = a.Timestamp
+			Info.Printf("Retrieved archive record for %s", a.Timestamp)
+		}
+		tok.Page = pageNum + 1
+
+		return c.progress(ctx, ec, progress, DmpProgress{
+			Pages:   tok.Page,
+			Total:   arcPages,
+			Records: tok.Records,
+		})
+	})
+	if err != nil {
+		err = &DmpError{Token: tok, Err: err}
+	}
+
+	return tok, err
+}
+
+// progress reports the progress of an archive download to the progress
+// function, if there is one, and as an event if progress events are
+// enabled.
+func (c Conn) progress(ctx context.Context, ec chan<- interface{}, fn ProgressFunc, dp DmpProgress) error {
+	if fn != nil {
+		fn(dp)
+	}
+	if !c.ProgressEvents {
+		return nil
+	}
+
+	return emit(ctx, ec, dp)
+}
+
+// StartArchiving starts the creation of archive records after they've been
+// stopped with StopArchiving.
+func (c Conn) StartArchiving() error {
+	return c.setArchiving(c.brokerContext(), true)
+}
+
+// StopArchiving stops the creation of archive records.
+func (c Conn) StopArchiving() error {
+	return c.setArchiving(c.brokerContext(), false)
+}
+
+// setArchiving starts or stops the creation of archive records.
+func (c Conn) setArchiving(ctx context.Context, on bool) (err error) {
+	cmd := "STOP\n"
+	if on {
+		cmd = "START\n"
+	}
+	_, err = c.writeCmd(ctx, []byte(cmd), []byte("\n\rOK\n\r"), 0)
+
+	return
+}
+
+// dmpPages reads n download memory pages, which the console has already
+// been told to send, and passes each one to fn.  Pages with a bad CRC are
+// requested again.  If the context is done, or fn returns an error, the
+// download is cancelled and the console is reset into a ready state.
+func (c Conn) dmpPages(ctx context.Context, n int, fn func(pageNum int, d data.Dmp) error) (err error) {
+	defer c.watch(ctx)()
+
+	cancel := func(pageNum int) error {
+		// Cancel the download and get the console back into a
+		// ready state.
+		Warn.Printf("Dmp download %d/%d cancelled: %s", pageNum, n, err)
+		c.d.Write([]byte{dmpEsc})
+		c.softReset()
+		return err
+	}
+
+	p := make([]byte, 267)
+	for pageNum := 0; pageNum < n; pageNum++ {
 		_, err = c.d.ReadFull(p)
-		if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			return cancel(pageNum)
+		} else if err != nil {
 			// Page read failed before we got all of the expected pages.
 			Error.Printf("Dmp download %d/%d interrupted: %s, aborting",
-				pageNum, dm.Pages, err.Error())
-			break
+				pageNum, n, err.Error())
+			return
 		}
 		Trace.Printf("Packet\n%s", hex.Dump(p))
 
 		d := data.Dmp{}
-		err = d.UnmarshalBinary(p)
+		err = d.UnmarshalBinaryIn(p, c.ConsLocation())
 		if err == data.ErrBadCRC {
 			// NAK and retry the page.
 			Error.Printf("Dmp page %d/%d error: %s, retrying",
-				pageNum, dm.Pages, err.Error())
-			c.d.Write([]byte{nak})
+				pageNum, n, err.Error())
+			c.d.Write([]byte{dmpNak})
 			pageNum--
 			continue
 		} else if err != nil {
 			Error.Printf("Dmp page %d/%d error: %s, aborting",
-				pageNum, dm.Pages, err.Error())
-			break
+				pageNum, n, err.Error())
+			return
 		}
 
 		// We have a valid decoded archive page
-		Debug.Printf("Valid dmp page (%d:%d/%d)", pageNum, int(p[0]), dm.Pages-1)
+		Debug.Printf("Valid dmp page (%d:%d/%d)", pageNum, int(p[0]), n-1)
 		Trace.Printf("Decoded\n%s", Sdump(d))
 
-		for recordNum := 0; recordNum < len(d); recordNum++ {
-			// On the first page skip anything before the offset
-			// given during the download setup.
-			//
-			// On the last page, after reading at least one
-			// record bail out as soon as we hit one where the
-			// date is older than the previous record.
-			if pageNum == 0 && recordNum < dm.FirstPageOffset {
-				continue
-			} else if pageNum == dm.Pages-1 &&
-				lastRec != newLastRec &&
-				newLastRec.After(d[recordNum].Timestamp) {
-				break
-			}
-
-			newLastRec = d[recordNum].Timestamp
-			ec <- d[recordNum]
-			Info.Printf("Retrieved archive record for %s", d[recordNum].Timestamp)
+		if err = fn(pageNum, d); err != nil {
+			return cancel(pageNum)
 		}
 
 		// ACK page as received OK so the next is sent.
@@ -4,22 +4,404 @@
 
 package weatherlink
 
-import "github.com/ebarkie/weatherlink/data"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ebarkie/weatherlink/data"
+	"github.com/ebarkie/weatherlink/packet"
+	"github.com/ebarkie/weatherlink/units"
+)
+
+// EEPROM addresses.
+const (
+	eeLat           = 0x0b // Latitude (tenths of a degree)
+	eeLon           = 0x0d // Longitude (tenths of a degree)
+	eeElev          = 0x0f // Elevation (feet)
+	eeTimeZone      = 0x11 // Time zone settings
+	eeTimeZoneSize  = 6    // Size of the time zone settings
+	eeUseTx         = 0x17 // Transmitters to listen to
+	eeUnitBits      = 0x29 // Unit bits
+	eeSetupBits     = 0x2b // Setup bits
+	eeArchivePeriod = 0x2d // Archive period (minutes)
+	eeCal           = 0x32 // Calibration offsets
+	eeCalSize       = 29   // Size of the calibration offsets
+	eeAlarms        = 0x52 // Alarm thresholds
+	eeAlarmsSize    = 94   // Size of the alarm thresholds
+	eeSize          = 4096 // Size of the EEPROM
+)
 
 // GetEEPROM retrieves the entire EEPROM configuration.
 func (c Conn) GetEEPROM(ec chan<- interface{}) error {
-	p, err := c.writeCmd([]byte("GETEE\n"), []byte{ack}, 4098)
+	return c.GetEEPROMContext(c.brokerContext(), ec)
+}
+
+// GetEEPROMContext is like GetEEPROM but aborts when the context is done.
+func (c Conn) GetEEPROMContext(ctx context.Context, ec chan<- interface{}) error {
+	_, err := c.getEEPROM(ctx, ec)
+
+	return err
+}
+
+// getEEPROM retrieves the entire EEPROM configuration, sends it to the
+// event channel, and returns it.
+func (c Conn) getEEPROM(ctx context.Context, ec chan<- interface{}) (ee data.EEPROM, err error) {
+	ee, err = c.readEEPROMImage(ctx)
 	if err != nil {
-		return err
+		return
+	}
+
+	select {
+	case ec <- ee:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	return
+}
+
+// readEEPROMImage reads and decodes the entire EEPROM.
+func (c Conn) readEEPROMImage(ctx context.Context) (ee data.EEPROM, err error) {
+	var p []byte
+	p, err = c.writeCmd(ctx, []byte("GETEE\n"), []byte{ack}, 4098)
+	if err != nil {
+		return
 	}
 
-	ee := data.EEPROM{}
 	err = ee.UnmarshalBinary(p)
+	if err != nil {
+		return
+	}
+	c.storeArchivePeriod(ee.ArchivePeriod)
+	c.storeTimeZone(ee.TimeZone)
+
+	return
+}
+
+// ReadEEPROMRange reads n bytes of the EEPROM starting at addr.
+func (c Conn) ReadEEPROMRange(addr, n int) ([]byte, error) {
+	return c.readEEPROM(c.brokerContext(), addr, n)
+}
+
+// readEEPROM reads n bytes of the EEPROM starting at addr.
+func (c Conn) readEEPROM(ctx context.Context, addr, n int) (p []byte, err error) {
+	if addr < 0 || n < 1 || addr+n > eeSize {
+		return nil, fmt.Errorf("%w: EEPROM range %#x+%d", ErrInvalidArg, addr, n)
+	}
+
+	p, err = c.writeCmd(ctx, []byte(fmt.Sprintf("EEBRD %02X %02X\n", addr, n)), []byte{ack}, n+2)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Crc(p) != 0 {
+		return nil, data.ErrBadCRC
+	}
+
+	return p[:n], nil
+}
+
+// ArchivePeriod returns the console archive period.  It's read from the
+// EEPROM when the device is opened and whenever the EEPROM is retrieved.
+func (c Conn) ArchivePeriod() time.Duration {
+	if c.arcPeriod == nil {
+		return defaultArcPeriod
+	}
+
+	return time.Duration(c.arcPeriod.Load())
+}
+
+// arcPeriods are the archive periods, in minutes, the console supports.
+var arcPeriods = []int{1, 5, 10, 15, 30, 60, 120}
+
+// validArcPeriod checks if an archive period in minutes is supported.
+func validArcPeriod(minutes int) bool {
+	for _, m := range arcPeriods {
+		if m == minutes {
+			return true
+		}
+	}
+
+	return false
+}
+
+// storeArchivePeriod stores the archive period from an EEPROM value in
+// minutes.  Values the console doesn't support are ignored.
+func (c Conn) storeArchivePeriod(minutes int) {
+	if !validArcPeriod(minutes) {
+		Warn.Printf("Ignoring invalid archive period of %d minutes", minutes)
+		return
+	}
+
+	if c.arcPeriod == nil {
+		return
+	}
+	if old := c.ArchivePeriod(); old != time.Duration(minutes)*time.Minute {
+		Info.Printf("Archive period is %d minutes (was %s)", minutes, old)
+		c.arcPeriod.Store(int64(time.Duration(minutes) * time.Minute))
+	}
+}
+
+// readArchivePeriod reads the archive period from the EEPROM.
+func (c Conn) readArchivePeriod(ctx context.Context) error {
+	p, err := c.readEEPROM(ctx, eeArchivePeriod, 1)
 	if err != nil {
 		return err
 	}
+	c.storeArchivePeriod(int(p[0]))
 
-	ec <- ee
+	return nil
+}
+
+// ConsLocation returns the console time zone, which archive record and
+// console times are in.  It's ConsLoc if that's set, otherwise it's read
+// from the EEPROM when the device is opened and whenever the EEPROM is
+// retrieved.  Until it's read it's local time.
+func (c Conn) ConsLocation() *time.Location {
+	if c.ConsLoc != nil {
+		return c.ConsLoc
+	}
+	if c.eeLoc != nil {
+		if loc := c.eeLoc.Load(); loc != nil {
+			return loc
+		}
+	}
+
+	return time.Local
+}
+
+// storeTimeZone stores the location of the EEPROM time zone settings.
+func (c Conn) storeTimeZone(tz data.EETimeZone) {
+	if c.eeLoc == nil {
+		return
+	}
+
+	loc := tz.Location()
+	if old := c.eeLoc.Load(); old == nil || old.String() != loc.String() {
+		Info.Printf("Console time zone is %s", loc)
+	}
+	c.eeLoc.Store(loc)
+}
+
+// readTimeZone reads the time zone settings from the EEPROM.
+func (c Conn) readTimeZone(ctx context.Context) error {
+	p, err := c.readEEPROM(ctx, eeTimeZone, eeTimeZoneSize)
+	if err != nil {
+		return err
+	}
+
+	var tz data.EETimeZone
+	if err = tz.UnmarshalBinary(p); err != nil {
+		return err
+	}
+	c.storeTimeZone(tz)
 
 	return nil
 }
+
+// WriteEEPROM writes the bytes to the EEPROM starting at addr and reads
+// them back to verify they were written.
+func (c Conn) WriteEEPROM(addr int, p []byte) error {
+	return c.writeEEPROM(c.brokerContext(), addr, p)
+}
+
+// writeEEPROM writes the bytes to the EEPROM starting at addr and reads
+// them back to verify they were written.
+func (c Conn) writeEEPROM(ctx context.Context, addr int, p []byte) (err error) {
+	if addr < 0 || len(p) < 1 || addr+len(p) > eeSize {
+		return fmt.Errorf("%w: EEPROM range %#x+%d", ErrInvalidArg, addr, len(p))
+	}
+
+	_, err = c.writeCmd(ctx, []byte(fmt.Sprintf("EEBWR %02X %02X\n", addr, len(p))), []byte{ack}, 0)
+	if err != nil {
+		return
+	}
+
+	b := make([]byte, len(p)+2)
+	copy(b, p)
+	packet.SetCrc(&b)
+	_, err = c.writeCmd(ctx, b, []byte{ack}, 0)
+	if err != nil {
+		return
+	}
+
+	var v []byte
+	v, err = c.readEEPROM(ctx, addr, len(p))
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(p, v) {
+		Error.Printf("EEPROM write at %#x did not verify", addr)
+		return ErrVerifyFailed
+	}
+
+	return
+}
+
+// NewSetup reinitializes the console so it uses the settings written to
+// the EEPROM.  It's required after changing the station list.
+func (c Conn) NewSetup() error {
+	return c.newSetup(c.brokerContext())
+}
+
+// newSetup reinitializes the console.
+func (c Conn) newSetup(ctx context.Context) (err error) {
+	_, err = c.writeCmd(ctx, []byte("NEWSETUP\n"), []byte{ack}, 0)
+
+	return
+}
+
+// SetArchivePeriod sets the console archive period in minutes, which
+// must be 1, 5, 10, 15, 30, 60, or 120.
+func (c Conn) SetArchivePeriod(minutes int) error {
+	return c.setArchivePeriod(c.brokerContext(), minutes)
+}
+
+// setArchivePeriod sets the console archive period in minutes and reads
+// it back to verify it.
+func (c Conn) setArchivePeriod(ctx context.Context, minutes int) (err error) {
+	if !validArcPeriod(minutes) {
+		return fmt.Errorf("%w: archive period %d", ErrInvalidArg, minutes)
+	}
+
+	_, err = c.writeCmd(ctx, []byte(fmt.Sprintf("SETPER %d\n", minutes)), []byte{ack}, 0)
+	if err != nil {
+		return
+	}
+
+	if err = c.readArchivePeriod(ctx); err != nil {
+		return
+	}
+	if c.ArchivePeriod() != time.Duration(minutes)*time.Minute {
+		return ErrVerifyFailed
+	}
+
+	return
+}
+
+// SetLocation sets the station latitude and longitude in degrees.
+// Negative values are south and west.
+func (c Conn) SetLocation(lat, lon float64) error {
+	return c.setLocation(c.brokerContext(), lat, lon)
+}
+
+// setLocation sets the station latitude and longitude along with the
+// hemisphere setup bits which must agree with them.
+func (c Conn) setLocation(ctx context.Context, lat, lon float64) (err error) {
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return fmt.Errorf("%w: location %g, %g", ErrInvalidArg, lat, lon)
+	}
+
+	p := make([]byte, 4)
+	packet.SetFloat16_10(&p, 0, lat)
+	packet.SetFloat16_10(&p, 2, lon)
+	if err = c.writeEEPROM(ctx, eeLat, p); err != nil {
+		return
+	}
+
+	var setup []byte
+	setup, err = c.readEEPROM(ctx, eeSetupBits, 1)
+	if err != nil {
+		return
+	}
+	setup[0] &^= 0xc0
+	if lat >= 0 {
+		setup[0] |= 0x40 // North
+	}
+	if lon >= 0 {
+		setup[0] |= 0x80 // East
+	}
+	if err = c.writeEEPROM(ctx, eeSetupBits, setup); err != nil {
+		return
+	}
+
+	return c.newSetup(ctx)
+}
+
+// SetElevation sets the station elevation in feet.  Any barometer
+// calibration offset is kept.
+func (c Conn) SetElevation(ft int) error {
+	return c.setElevation(c.brokerContext(), ft)
+}
+
+// setElevation writes the station elevation to the EEPROM.  BAR= would
+// also set it but clears the barometer calibration offset.
+func (c Conn) setElevation(ctx context.Context, ft int) (err error) {
+	if ft < -2000 || ft > 15000 {
+		return fmt.Errorf("%w: elevation %d", ErrInvalidArg, ft)
+	}
+
+	// The elevation is stored in the console's elevation unit.
+	var unit []byte
+	unit, err = c.readEEPROM(ctx, eeUnitBits, 1)
+	if err != nil {
+		return
+	}
+	elev := float64(ft)
+	if unit[0]&0x10 != 0 {
+		elev = units.Length(elev * 12.0).Meters()
+	}
+
+	p := make([]byte, 2)
+	packet.SetUInt16(&p, 0, int(math.Round(elev)))
+	if err = c.writeEEPROM(ctx, eeElev, p); err != nil {
+		return
+	}
+
+	return c.newSetup(ctx)
+}
+
+// SetTimeZone sets the console time zone and daylight savings settings.
+func (c Conn) SetTimeZone(tz data.EETimeZone) error {
+	return c.setTimeZone(c.brokerContext(), tz)
+}
+
+// setTimeZone sets the console time zone and daylight savings settings.
+func (c Conn) setTimeZone(ctx context.Context, tz data.EETimeZone) (err error) {
+	p, err := tz.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidArg, err.Error())
+	}
+	if err = c.writeEEPROM(ctx, eeTimeZone, p); err != nil {
+		return
+	}
+	c.storeTimeZone(tz)
+
+	return c.newSetup(ctx)
+}
+
+// SetStations sets the transmitter station list and the ID the console
+// retransmits as, or 0 if it doesn't.
+func (c Conn) SetStations(stations [8]data.EEStation, retransmit int) error {
+	return c.setStations(c.brokerContext(), stations, retransmit)
+}
+
+// setStations sets the transmitters the console listens to, the station
+// list, and the retransmit ID.
+func (c Conn) setStations(ctx context.Context, stations [8]data.EEStation, retransmit int) (err error) {
+	if retransmit < 0 || retransmit > len(stations) {
+		return fmt.Errorf("%w: retransmit ID %d", ErrInvalidArg, retransmit)
+	}
+
+	// The transmitters to listen to, the retransmit ID, and the station
+	// list are laid out one after the other.
+	p := make([]byte, 2+2*len(stations))
+	for i, s := range stations {
+		if s.Active {
+			p[0] |= 1 << uint(i)
+		}
+		var b []byte
+		if b, err = s.MarshalBinary(); err != nil {
+			return fmt.Errorf("%w: station %d %s", ErrInvalidArg, i+1, err.Error())
+		}
+		copy(p[2+2*i:], b)
+	}
+	p[1] = byte(retransmit)
+	if err = c.writeEEPROM(ctx, eeUseTx, p); err != nil {
+		return
+	}
+
+	return c.newSetup(ctx)
+}
@@ -5,6 +5,7 @@
 package weatherlink
 
 import (
+	"context"
 	"time"
 
 	"github.com/ebarkie/weatherlink/data"
@@ -12,7 +13,12 @@ import (
 
 // GetFirmBuildTime gets the firmware build time.
 func (c Conn) GetFirmBuildTime() (time.Time, error) {
-	p, err := c.writeCmd([]byte("VER\n"), []byte("\n\rOK\n\r"), 13)
+	return c.getFirmBuildTime(c.brokerContext())
+}
+
+// getFirmBuildTime gets the firmware build time.
+func (c Conn) getFirmBuildTime(ctx context.Context) (time.Time, error) {
+	p, err := c.writeCmd(ctx, []byte("VER\n"), []byte("\n\rOK\n\r"), 13)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -24,7 +30,12 @@ func (c Conn) GetFirmBuildTime() (time.Time, error) {
 
 // GetFirmVer gets the firmware version number.
 func (c Conn) GetFirmVer() (string, error) {
-	p, err := c.writeCmd([]byte("NVER\n"), []byte("\n\rOK\n\r"), 6)
+	return c.getFirmVer(c.brokerContext())
+}
+
+// getFirmVer gets the firmware version number.
+func (c Conn) getFirmVer(ctx context.Context) (string, error) {
+	p, err := c.writeCmd(ctx, []byte("NVER\n"), []byte("\n\rOK\n\r"), 6)
 	if err != nil {
 		return "", err
 	}
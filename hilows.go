@@ -4,22 +4,43 @@
 
 package weatherlink
 
-import "github.com/ebarkie/weatherlink/data"
+import (
+	"context"
+
+	"github.com/ebarkie/weatherlink/data"
+)
 
 // GetHiLows retrieves the record high and lows.
 func (c Conn) GetHiLows(ec chan<- interface{}) error {
-	p, err := c.writeCmd([]byte("HILOWS\n"), []byte{ack}, 438)
+	return c.GetHiLowsContext(c.brokerContext(), ec)
+}
+
+// GetHiLowsContext is like GetHiLows but aborts when the context is done.
+func (c Conn) GetHiLowsContext(ctx context.Context, ec chan<- interface{}) error {
+	_, err := c.getHiLows(ctx, ec)
+
+	return err
+}
+
+// getHiLows retrieves the record high and lows, sends them to the event
+// channel, and returns them.
+func (c Conn) getHiLows(ctx context.Context, ec chan<- interface{}) (hl data.HiLows, err error) {
+	var p []byte
+	p, err = c.writeCmd(ctx, []byte("HILOWS\n"), []byte{ack}, 438)
 	if err != nil {
-		return err
+		return
 	}
 
-	hl := data.HiLows{}
 	err = hl.UnmarshalBinary(p)
 	if err != nil {
-		return err
+		return
 	}
 
-	ec <- hl
+	select {
+	case ec <- hl:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
 
-	return nil
+	return
 }
@@ -0,0 +1,155 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package device
+
+// A session with a device is recorded as one line per operation:
+//
+//	<RFC 3339 time> <op> <hex data or -> [error]
+//
+// Where op is d (dial), w (write), r (read), f (flush), or c (close).  For
+// dials the data is the address.
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Dev is the interface of the devices in this package.
+type Dev interface {
+	io.ReadWriteCloser
+	Dial(addr string) error
+	Flush() error
+	ReadFull(buf []byte) (n int, err error)
+}
+
+// Session operations.
+const (
+	opDial  = "d"
+	opWrite = "w"
+	opRead  = "r"
+	opFlush = "f"
+	opClose = "c"
+)
+
+// Recorder is a device which records the session with the device it
+// wraps.
+type Recorder struct {
+	Dev
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// baudDev is a device whose baud rate can be changed.
+type baudDev interface {
+	GetBaud() int
+	SetBaud(baud int) error
+}
+
+// baudRecorder is a Recorder for a device whose baud rate can be changed.
+type baudRecorder struct {
+	*Recorder
+	d baudDev
+}
+
+// NewRecorder returns a Recorder which records the session with d to w.
+// If the baud rate of d can be changed then so can the recorder's.
+func NewRecorder(d Dev, w io.Writer) Dev {
+	r := &Recorder{Dev: d, w: w}
+	if bd, ok := d.(baudDev); ok {
+		return baudRecorder{Recorder: r, d: bd}
+	}
+
+	return r
+}
+
+// record writes an operation to the session.
+func (r *Recorder) record(op string, p []byte, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := "-"
+	if len(p) > 0 {
+		data = hex.EncodeToString(p)
+	}
+	line := time.Now().UTC().Format(time.RFC3339Nano) + " " + op + " " + data
+	if err != nil {
+		line += " " + err.Error()
+	}
+	fmt.Fprintln(r.w, line)
+}
+
+// Close closes the device.
+func (r *Recorder) Close() error {
+	err := r.Dev.Close()
+	r.record(opClose, nil, err)
+
+	return err
+}
+
+// Dial dials the device.
+func (r *Recorder) Dial(addr string) error {
+	err := r.Dev.Dial(addr)
+	r.record(opDial, []byte(addr), err)
+
+	return err
+}
+
+// Flush flushes the device input buffers.
+func (r *Recorder) Flush() error {
+	err := r.Dev.Flush()
+	r.record(opFlush, nil, err)
+
+	return err
+}
+
+// Read reads up to the size of the provided byte buffer from the device.
+func (r *Recorder) Read(b []byte) (n int, err error) {
+	n, err = r.Dev.Read(b)
+	r.record(opRead, b[:n], err)
+
+	return
+}
+
+// ReadFull reads the full size of the provided byte buffer from the
+// device.
+func (r *Recorder) ReadFull(b []byte) (n int, err error) {
+	n, err = r.Dev.ReadFull(b)
+	r.record(opRead, b[:n], err)
+
+	return
+}
+
+// SetReadDeadline sets the read deadline of the device.  If the device
+// doesn't support them it returns ErrNotSupported.
+func (r *Recorder) SetReadDeadline(t time.Time) error {
+	dl, ok := r.Dev.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return dl.SetReadDeadline(t)
+}
+
+// Write writes the byte buffer to the device.
+func (r *Recorder) Write(b []byte) (n int, err error) {
+	n, err = r.Dev.Write(b)
+	r.record(opWrite, b[:n], err)
+
+	return
+}
+
+// GetBaud returns the current baud rate of the device.
+func (r baudRecorder) GetBaud() int {
+	return r.d.GetBaud()
+}
+
+// SetBaud changes the baud rate of the device.
+func (r baudRecorder) SetBaud(baud int) error {
+	return r.d.SetBaud(baud)
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Errors.
+var (
+	ErrNotSupported   = errors.New("not supported by device")
+	ErrReplayMismatch = errors.New("replay mismatch")
+)
+
+// op is an operation from a recorded session.
+type op struct {
+	line int
+	op   string
+	p    []byte
+	err  error
+}
+
+// Replay is a device which plays back a session recorded by a Recorder.
+// Writes must match what was recorded and reads return what was recorded,
+// including errors.  Timing is not reproduced.
+type Replay struct {
+	mu  sync.Mutex
+	ops []op
+	i   int
+}
+
+// NewReplay returns a Replay for the session read from r.
+func NewReplay(r io.Reader) (*Replay, error) {
+	rp := &Replay{}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		f := strings.SplitN(line, " ", 4)
+		if len(f) < 3 {
+			return nil, fmt.Errorf("replay line %d: too few fields", n)
+		}
+		o := op{line: n, op: f[1]}
+		if f[2] != "-" {
+			var err error
+			o.p, err = hex.DecodeString(f[2])
+			if err != nil {
+				return nil, fmt.Errorf("replay line %d: %s", n, err)
+			}
+		}
+		if len(f) == 4 {
+			o.err = replayErr(f[3])
+		}
+		rp.ops = append(rp.ops, o)
+	}
+
+	return rp, s.Err()
+}
+
+// replayErr returns the error for a recorded error message, using the
+// standard io errors where possible so they can be compared.
+func replayErr(msg string) error {
+	switch msg {
+	case io.EOF.Error():
+		return io.EOF
+	case io.ErrUnexpectedEOF.Error():
+		return io.ErrUnexpectedEOF
+	}
+
+	return errors.New(msg)
+}
+
+// next returns the next operation if it's of the requested type.
+func (rp *Replay) next(o string) (op, bool) {
+	if rp.i >= len(rp.ops) || rp.ops[rp.i].op != o {
+		return op{}, false
+	}
+	rp.i++
+
+	return rp.ops[rp.i-1], true
+}
+
+// control plays back a dial, flush, or close.  These are only checked
+// against the session if they were recorded at this point.
+func (rp *Replay) control(o string) error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	r, _ := rp.next(o)
+	return r.err
+}
+
+// Close plays back a close.
+func (rp *Replay) Close() error {
+	return rp.control(opClose)
+}
+
+// Dial plays back a dial.
+func (rp *Replay) Dial(string) error {
+	return rp.control(opDial)
+}
+
+// Flush plays back a flush.
+func (rp *Replay) Flush() error {
+	return rp.control(opFlush)
+}
+
+// Read plays back a read.
+func (rp *Replay) Read(b []byte) (int, error) {
+	return rp.ReadFull(b)
+}
+
+// ReadFull plays back a read.  If the session is over it returns io.EOF.
+func (rp *Replay) ReadFull(b []byte) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.i >= len(rp.ops) {
+		return 0, io.EOF
+	}
+	r, ok := rp.next(opRead)
+	if !ok {
+		return 0, fmt.Errorf("%w: line %d: unexpected read", ErrReplayMismatch, rp.ops[rp.i].line)
+	}
+
+	n := copy(b, r.p)
+	if r.err == nil && n < len(b) {
+		return n, io.ErrUnexpectedEOF
+	}
+
+	return n, r.err
+}
+
+// Write plays back a write.  If the session is over it returns io.EOF.
+func (rp *Replay) Write(b []byte) (int, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if rp.i >= len(rp.ops) {
+		return 0, io.EOF
+	}
+	w := rp.ops[rp.i]
+	if w.op != opWrite || !bytes.Equal(w.p, b) {
+		return 0, fmt.Errorf("%w: line %d: unexpected write %x",
+			ErrReplayMismatch, w.line, b)
+	}
+	rp.i++
+
+	return len(w.p), w.err
+}
+
+// Done returns true if the entire session was played back.
+func (rp *Replay) Done() bool {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	return rp.i >= len(rp.ops)
+}
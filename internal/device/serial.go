@@ -11,19 +11,74 @@ import (
 	"github.com/pkg/term"
 )
 
+// DefaultBaud is the baud rate used if none is specified.  It's the
+// console's factory default.
+const DefaultBaud = 19200
+
+// FlowControl is a serial flow control mode.
+type FlowControl int
+
+// Flow control modes.
+const (
+	NoFlowControl FlowControl = iota
+	SoftwareFlowControl
+	HardwareFlowControl
+)
+
 // Serial represents a Weatherlink serial or USB device.
 type Serial struct {
 	*term.Term
-	Timeout time.Duration
+	Baud        int           // Baud rate, DefaultBaud if 0
+	FlowControl FlowControl   // Flow control mode
+	Timeout     time.Duration // Read timeout
 }
 
 // Dial opens a serial port connection with a weatherlink device.
 func (s *Serial) Dial(addr string) (err error) {
-	s.Term, err = term.Open(addr, term.Speed(19200), term.ReadTimeout(s.Timeout), term.RawMode)
+	var fc int
+	switch s.FlowControl {
+	case SoftwareFlowControl:
+		fc = term.XONXOFF
+	case HardwareFlowControl:
+		fc = term.HARDWARE
+	default:
+		fc = term.NONE
+	}
+
+	s.Term, err = term.Open(addr,
+		term.Speed(s.baud()),
+		term.ReadTimeout(s.Timeout),
+		term.RawMode,
+		term.FlowControl(fc))
 
 	return
 }
 
+// baud returns the baud rate to use.
+func (s Serial) baud() int {
+	if s.Baud == 0 {
+		return DefaultBaud
+	}
+
+	return s.Baud
+}
+
+// GetBaud returns the current baud rate.
+func (s Serial) GetBaud() int {
+	return s.baud()
+}
+
+// SetBaud changes the baud rate.  If the port is open it's changed
+// immediately, otherwise it's used when the port is opened.
+func (s *Serial) SetBaud(baud int) error {
+	s.Baud = baud
+	if s.Term == nil {
+		return nil
+	}
+
+	return s.Term.SetSpeed(baud)
+}
+
 // ReadFull reads the full size of the provided byte buffer from the
 // Weatherlink device.  It blocks until the entire buffer is filled
 // or the timeout triggers.
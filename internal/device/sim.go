@@ -4,125 +4,866 @@
 
 package device
 
-// A Weatherlink device is simulated by guessing what commands were
-// requested based on the packet sizes.  It's not perfect but is a
-// convenient way to allow low level protocol testing.
+// A Weatherlink device is simulated by parsing the commands written to
+// it and queuing the console's responses to be read.  The console state,
+// including the archive memory, EEPROM, and record highs and lows, is
+// kept so responses are consistent with each other.
 
 import (
+	"bytes"
 	"io"
 	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ebarkie/weatherlink/data"
+	"github.com/ebarkie/weatherlink/packet"
 )
 
+// Simulated console protocol bytes.
+const (
+	simAck    = 0x06 // Acknowledge
+	simCancel = 0x18 // Cancel
+	simDmpNak = 0x15 // Not acknowledge (DMP)
+	simEsc    = 0x1b // Escape (DMP)
+	simNak    = 0x21 // Not acknowledge
+)
+
+// Simulated console memory sizes.
+const (
+	simArcRecs = 2560 // Archive records
+	simEESize  = 4096 // EEPROM bytes
+)
+
+// Simulated console states.
+const (
+	simIdle       = iota // Waiting for a command
+	simSetTime           // Waiting for SETTIME time
+	simEEBWR             // Waiting for EEBWR data
+	simDmpAftTime        // Waiting for DMPAFT time
+	simDmpStart          // Waiting for DMP download to be started
+	simDmp               // Sending DMP pages
+	simLoops             // Sending loop packets
+)
+
+// Faults are the rates, from 0 to 1, at which a simulated Weatherlink
+// device injects faults into its responses.
+type Faults struct {
+	CRC    float64 // Packet CRC is corrupted
+	Drop   float64 // A byte is dropped from a response
+	Stall  float64 // A command's first read stalls until the timeout and returns nothing
+	Wakeup float64 // A spurious wakeup response precedes a response
+	BadAck float64 // An ACK is replaced with garbage
+
+	Rand *rand.Rand // Random source, the default Source if nil
+}
+
+// hit randomly decides if a fault with the given rate occurs.
+func (f Faults) hit(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	if f.Rand == nil {
+		return rand.Float64() < rate
+	}
+	return f.Rand.Float64() < rate
+}
+
+// intn returns a random number in [0,n).
+func (f Faults) intn(n int) int {
+	if f.Rand == nil {
+		return rand.Intn(n)
+	}
+	return f.Rand.Intn(n)
+}
+
 // Sim represents a simulted Weatherlink device.
 type Sim struct {
-	l            data.Loop // Current loop packet state
-	nextLoopType int       // Loop type to send next (so they are interleaved)
+	Clock     func() time.Time // Console clock source, time.Now if nil
+	LoopDelay time.Duration    // Delay between loop packets, 2s if 0
+	Faults    Faults           // Fault injection rates
+	Timeout   time.Duration    // Read timeout when a read stalls
+
+	mu    sync.Mutex
+	rand  *rand.Rand // Random source for observation values
+	state int
+	out   bytes.Buffer // Pending responses
+	stall bool         // Next read stalls
+
+	l            data.Loop     // Current loop packet state
+	nextLoopType int           // Loop type to send next (so they are interleaved)
+	loops        int           // Loop packets left to send
+	offset       time.Duration // Console clock offset from the clock source
+
+	ee    []byte // EEPROM
+	eeBWR [2]int // EEBWR address and length
 
-	// lastWrite and readsSinceWrite are used by ReadFull() to determine
-	// what's expected to be read.  This is simple and avoids implementing
-	// a state machine.
-	lastWrite       []byte
-	readsSinceWrite int
+	arc     [simArcRecs]data.Archive // Archive memory
+	arcNext int                      // Index the next record will be written to
+	arcLen  int                      // Number of records in memory
+	arcLast time.Time                // Time of the last record written
+
+	arcStopped bool // Archive records aren't being created
+
+	pages [][]byte // DMP pages to send
+	page  int      // DMP page being sent
 }
 
 // Dial initializes the state of a simulated Weatherlink device.
 func (s *Sim) Dial(addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	// Starting loop values which will pass typical QC processes.
 	s.l.Bar.Altimeter = 29.0
 	s.l.Bar.SeaLevel = 29.0
 	s.l.Bar.Station = 29.0
+	s.l.InHumidity = 40
+	s.l.InTemp = 70.0
 	s.l.OutHumidity = 50
 	s.l.OutTemp = 65.0
 	s.l.Wind.Cur.Speed = 3
 
+	if s.rand == nil {
+		// Derive from the fault source so seeded simulations repeat.
+		seed := time.Now().UnixNano()
+		if s.Faults.Rand != nil {
+			seed = s.Faults.Rand.Int63()
+		}
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+
+	s.state = simIdle
+	s.out.Reset()
+	s.stall = false
+	if s.ee == nil {
+		s.ee = simEEPROM()
+	}
+
+	// Start with a day of archive records.
+	if s.arcLen == 0 {
+		period := s.archivePeriod()
+		s.arcLast = s.now().Truncate(period).Add(-24 * time.Hour)
+		s.archive(s.now())
+	}
+
 	return nil
 }
 
 // Close closes the simulated Weatherlink device.
 func (s *Sim) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.l = data.Loop{}
 	s.nextLoopType = 0
+	s.state = simIdle
+	s.out.Reset()
 
 	return nil
 }
 
 // Flush flushes the input buffers of the simulated Weatherlink device.
-func (Sim) Flush() error {
+func (s *Sim) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.out.Reset()
+
 	return nil
 }
 
 // Read reads up to the size of the provided byte buffer from the
-// simulated Weatherlink device.
-func (Sim) Read([]byte) (int, error) {
-	return 0, io.ErrUnexpectedEOF
+// simulated Weatherlink device.  If nothing is pending it waits for a
+// byte, such as the start of the next loop packet.
+func (s *Sim) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	n := s.out.Len()
+	s.mu.Unlock()
+
+	if n < 1 {
+		n = 1
+	}
+	if n > len(b) {
+		n = len(b)
+	}
+
+	return s.ReadFull(b[:n])
 }
 
 // ReadFull reads the full size of the provided byte buffer from the
-// simulted Weatherlink device.
+// simulted Weatherlink device.  If not enough of a response is available
+// it returns what there is and io.ErrUnexpectedEOF, like a timeout would.
 func (s *Sim) ReadFull(b []byte) (n int, err error) {
-	const ack = 0x06 // Acknowledge
-
-	s.readsSinceWrite++
-
-	var p []byte
-	switch {
-	case len(b) == 1: // Command ack
-		p = []byte{ack}
-	case len(b) == 6 && s.readsSinceWrite < 2: // Command OK
-		p = []byte("\n\rOK\n\r")
-	case string(s.lastWrite) == "GETTIME\n":
-		ct := data.ConsTime(time.Now())
-		p, err = ct.MarshalBinary()
-	case string(s.lastWrite) == "NVER\n":
-		fv := data.FirmVer("1.73")
-		p, err = fv.MarshalText()
-	case string(s.lastWrite) == "TEST\n":
-		p = []byte("\n\rTEST\n\r")
-	case string(s.lastWrite) == "VER\n":
-		ft := data.FirmTime(time.Date(2002, time.April, 24, 0, 0, 0, 0, time.UTC))
-		p, err = ft.MarshalText()
-	case len(b) == 99: // LPS 3 x
-		// Interleave loop types.
-		s.l.LoopType = s.nextLoopType + 1
-		s.nextLoopType = (s.nextLoopType + 1) % 2
-
-		// Make observation values wander around like they would on a
-		// real station.
-		s.l.Bar.Altimeter = wander(s.l.Bar.Altimeter, 0.01)
-		s.l.Bar.SeaLevel = wander(s.l.Bar.SeaLevel, 0.01)
-		s.l.Bar.Station = wander(s.l.Bar.Station, 0.01)
-		s.l.OutHumidity = int(wander(float64(s.l.OutHumidity), 1))
-		s.l.OutTemp = wander(s.l.OutTemp, 0.5)
-		s.l.Wind.Cur.Speed = int(wander(float64(s.l.Wind.Cur.Speed), 1))
-
-		s.l.LoopType = s.nextLoopType + 1
-		s.nextLoopType = (s.nextLoopType + 1) % 2
-
-		p, err = s.l.MarshalBinary()
-
-		// Create 2s delay between packets.
-		time.Sleep(2 * time.Second)
-	default:
+	s.mu.Lock()
+	for s.state == simLoops && s.out.Len() < len(b) {
+		// Create delay between packets like the console does.  The
+		// lock is released so the stream can be cancelled meanwhile.
+		s.mu.Unlock()
+		delay := s.LoopDelay
+		if delay == 0 {
+			delay = 2 * time.Second
+		}
+		time.Sleep(delay)
+		s.mu.Lock()
+		if s.state != simLoops {
+			break
+		}
+		s.loop()
+	}
+	defer s.mu.Unlock()
+
+	if s.stall {
+		// The console didn't answer in time so the response, if any,
+		// arrives late.
+		s.stall = false
+		s.mu.Unlock()
+		time.Sleep(s.Timeout)
+		s.mu.Lock()
 		return 0, io.ErrUnexpectedEOF
 	}
 
-	n = copy(b, p)
+	n, _ = s.out.Read(b)
+	if n < len(b) {
+		err = io.ErrUnexpectedEOF
+	}
+
 	return
 }
 
 // Write simulates a write of the byte buffer.
 func (s *Sim) Write(b []byte) (int, error) {
-	s.lastWrite = b
-	s.readsSinceWrite = 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.archive(s.now())
+	s.stall = s.Faults.hit(s.Faults.Stall)
+
+	switch s.state {
+	case simSetTime:
+		var ct data.ConsTime
+		if ct.UnmarshalBinaryIn(b, s.location()) != nil {
+			s.reply([]byte{simCancel}, false)
+		} else {
+			s.offset += time.Time(ct).Sub(s.now())
+			s.ack()
+		}
+		s.state = simIdle
+	case simEEBWR:
+		if addr, n := s.eeBWR[0], s.eeBWR[1]; len(b) != n+2 || packet.Crc(b) != 0 {
+			s.reply([]byte{simCancel}, false)
+		} else {
+			copy(s.ee[addr:addr+n], b)
+			s.ack()
+		}
+		s.state = simIdle
+	case simDmpAftTime:
+		var da data.DmpAft
+		if da.UnmarshalBinary(b) != nil {
+			s.reply([]byte{simCancel}, false)
+			s.state = simIdle
+			break
+		}
+		s.ack()
+		s.dmpAft(b[:4])
+	case simDmpStart, simDmp:
+		s.dmp(b)
+	case simLoops:
+		// Anything written cancels the loop stream.
+		s.loops = 0
+		s.state = simIdle
+		s.command(b)
+	default:
+		s.command(b)
+	}
 
 	return len(b), nil
 }
 
-// wander takes a value and randomly adds +/- step or zero.
-func wander(v, step float64) float64 {
-	rand.Seed(int64(time.Now().Nanosecond()))
-	return v + float64(rand.Intn(3)-1)*step
+// command processes a command.
+func (s *Sim) command(b []byte) {
+	ok := func() { s.reply([]byte("\n\rOK\n\r"), false) }
+
+	f := strings.Fields(string(b))
+	if len(f) < 1 {
+		// Wakeup
+		s.reply([]byte("\n\r"), false)
+		return
+	}
+
+	if strings.HasPrefix(f[0], "BAR=") {
+		s.bar(f)
+		return
+	}
+
+	switch f[0] {
+	case "BARDATA":
+		ok()
+		p, _ := data.BarData{
+			Bar:         s.l.Bar.SeaLevel,
+			BarCal:      packet.GetFloat16(s.ee, 0x05) / 1000.0,
+			DewPoint:    int(s.l.DewPoint),
+			Elev:        packet.GetUInt16(s.ee, 0x0f),
+			Gain:        1930,
+			Offset:      -2530,
+			Ratio:       1.0,
+			VirtualTemp: int(s.l.OutTemp),
+		}.MarshalText()
+		s.reply(p, false)
+	case "CLRALM":
+		// Barometer trend thresholds are unset with 0, the alarm time
+		// with 0xffff and a complement of 0, and everything else with
+		// all bits set.
+		for i := uint(0x52); i < 0xb0; i++ {
+			packet.SetUInt8(&s.ee, i, 0xff)
+		}
+		packet.SetUInt16(&s.ee, 0x52, 0)
+		packet.SetUInt16(&s.ee, 0x56, 0)
+		ok()
+		s.reply([]byte("DONE\n\r"), false)
+	case "CLRCAL":
+		// Temperature and humidity offsets, the wind direction offset is
+		// kept.
+		for i := uint(0x32); i < 0x4d; i++ {
+			packet.SetUInt8(&s.ee, i, 0)
+		}
+		packet.SetUInt8(&s.ee, 0x33, 0xff) // Inside temperature complement
+		ok()
+		s.reply([]byte("DONE\n\r"), false)
+	case "CLRGRA":
+		ok()
+		s.reply([]byte("DONE\n\r"), false)
+	case "CLRHIGHS", "CLRLOWS":
+		// Highs and lows are derived from the archive memory so there's
+		// nothing to clear.
+		if len(f) < 2 || (f[1] != "0" && f[1] != "1" && f[1] != "2") {
+			s.reply([]byte{simNak}, false)
+			return
+		}
+		s.ack()
+	case "CLRLOG":
+		s.arc = [simArcRecs]data.Archive{}
+		s.arcNext, s.arcLen = 0, 0
+		s.ack()
+	case "CLRVAR":
+		if len(f) < 2 {
+			return
+		}
+		switch n, _ := strconv.Atoi(f[1]); n {
+		case 13:
+			s.l.Rain.Accum.Today = 0
+		case 14:
+			s.l.Rain.Accum.Storm = 0
+		case 16:
+			s.l.Rain.Accum.LastMonth = 0
+		case 17:
+			s.l.Rain.Accum.LastYear = 0
+		case 25:
+			s.l.ET.LastMonth = 0
+		case 26:
+			s.l.ET.Today = 0
+		case 27:
+			s.l.ET.LastYear = 0
+		default:
+			s.reply([]byte{simNak}, false)
+			return
+		}
+		s.ack()
+	case "DMP":
+		s.ack()
+		s.dmpAll()
+	case "DMPAFT":
+		s.ack()
+		s.state = simDmpAftTime
+	case "EEBRD":
+		if len(f) < 3 {
+			return
+		}
+		addr, err1 := strconv.ParseUint(f[1], 16, 16)
+		n, err2 := strconv.ParseUint(f[2], 16, 16)
+		if err1 != nil || err2 != nil || addr+n > simEESize {
+			s.reply([]byte{simNak}, false)
+			return
+		}
+		s.ack()
+		p := make([]byte, n+2)
+		copy(p, s.ee[addr:])
+		packet.SetCrc(&p)
+		s.reply(p, true)
+	case "EEBWR":
+		if len(f) < 3 {
+			return
+		}
+		addr, err1 := strconv.ParseUint(f[1], 16, 16)
+		n, err2 := strconv.ParseUint(f[2], 16, 16)
+		if err1 != nil || err2 != nil || addr+n > simEESize {
+			s.reply([]byte{simNak}, false)
+			return
+		}
+		s.eeBWR = [2]int{int(addr), int(n)}
+		s.ack()
+		s.state = simEEBWR
+	case "GETEE":
+		s.ack()
+		p := make([]byte, simEESize+2)
+		copy(p, s.ee)
+		packet.SetCrc(&p)
+		s.reply(p, true)
+	case "GETTIME":
+		s.ack()
+		p, _ := data.ConsTime(s.now().In(s.location())).MarshalBinary()
+		s.reply(p, true)
+	case "HILOWS":
+		s.ack()
+		p, _ := s.hiLows().MarshalBinary()
+		s.reply(p, true)
+	case "LAMPS":
+		ok()
+	case "LPS":
+		if len(f) < 3 {
+			return
+		}
+		s.loops, _ = strconv.Atoi(f[2])
+		s.ack()
+		s.state = simLoops
+	case "NEWSETUP":
+		s.ack()
+	case "NVER":
+		ok()
+		p, _ := data.FirmVer("1.73").MarshalText()
+		s.reply(p, false)
+	case "PUTET":
+		var ye data.YearET
+		if len(f) < 2 || ye.UnmarshalText([]byte(f[1])) != nil {
+			s.reply([]byte{simNak}, false)
+			return
+		}
+		s.l.ET.LastYear = float64(ye)
+		s.ack()
+	case "PUTRAIN":
+		var yr data.YearRain
+		if len(f) < 2 || yr.UnmarshalText([]byte(f[1])) != nil {
+			s.reply([]byte{simNak}, false)
+			return
+		}
+		s.l.Rain.Accum.LastYear = float64(yr)
+		s.ack()
+	case "RECEIVERS":
+		// Every transmitter listened to is received.
+		ok()
+		s.reply([]byte{s.ee[0x17]}, false)
+	case "RXCHECK":
+		// Transmitter 1 sends a packet every 2.5 seconds and they're all
+		// received.
+		ok()
+		now := s.now().In(s.location())
+		n := int(now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())) /
+			(2500 * time.Millisecond))
+		p, _ := data.RxStats{Received: n, MaxInRow: n}.MarshalText()
+		s.reply(p, false)
+	case "SETPER":
+		if len(f) < 2 {
+			return
+		}
+		switch n, _ := strconv.Atoi(f[1]); n {
+		case 1, 5, 10, 15, 30, 60, 120:
+			packet.SetUInt8(&s.ee, 0x2d, n)
+			s.ack()
+		default:
+			s.reply([]byte{simNak}, false)
+		}
+	case "SETTIME":
+		s.ack()
+		s.state = simSetTime
+	case "START", "STOP":
+		s.arcStopped = f[0] == "STOP"
+		ok()
+	case "TEST":
+		s.reply([]byte("\n\rTEST\n\r"), false)
+	case "VER":
+		ok()
+		p, _ := data.FirmTime(time.Date(2002, time.April, 24, 0, 0, 0, 0, time.UTC)).MarshalText()
+		s.reply(p, false)
+	}
+}
+
+// bar processes a BAR= command which sets the elevation and, if a
+// barometer reading is given, the barometer offset so the console
+// displays it.
+func (s *Sim) bar(f []string) {
+	bar, err1 := strconv.Atoi(strings.TrimPrefix(f[0], "BAR="))
+	var elev int
+	var err2 error
+	if len(f) > 1 {
+		elev, err2 = strconv.Atoi(f[1])
+	}
+	if err1 != nil || err2 != nil || len(f) < 2 ||
+		(bar != 0 && (bar < 20000 || bar > 32500)) || elev < -2000 || elev > 15000 {
+		s.reply([]byte{simNak}, false)
+		return
+	}
+
+	var cal float64
+	if bar != 0 {
+		cal = float64(bar) - s.l.Bar.SeaLevel*1000.0
+	}
+	packet.SetFloat16(&s.ee, 0x05, cal)
+	packet.SetUInt16(&s.ee, 0x0f, elev)
+	s.reply([]byte("\n\rOK\n\r"), false)
+}
+
+// ack queues an acknowledgement.
+func (s *Sim) ack() {
+	if s.Faults.hit(s.Faults.BadAck) {
+		s.reply([]byte{byte(simAck + 1 + s.Faults.intn(0xff))}, false)
+		return
+	}
+
+	s.reply([]byte{simAck}, false)
+}
+
+// reply queues a response.  If it's a packet with a CRC it may be
+// corrupted along with the other injected faults.
+func (s *Sim) reply(p []byte, crc bool) {
+	if s.Faults.hit(s.Faults.Wakeup) {
+		s.out.WriteString("\n\r")
+	}
+
+	if crc && len(p) > 2 && s.Faults.hit(s.Faults.CRC) {
+		// Copy so the original, such as a DMP page, is unchanged if
+		// it's sent again.
+		p = append([]byte{}, p...)
+		p[len(p)-1] ^= 0xff
+	}
+
+	if len(p) > 0 && s.Faults.hit(s.Faults.Drop) {
+		i := s.Faults.intn(len(p))
+		p = append(append([]byte{}, p[:i]...), p[i+1:]...)
+	}
+
+	s.out.Write(p)
+}
+
+// now returns the console time.
+func (s *Sim) now() time.Time {
+	clock := s.Clock
+	if clock == nil {
+		clock = time.Now
+	}
+
+	return clock().Add(s.offset)
+}
+
+// archivePeriod returns the archive period from the EEPROM.
+func (s *Sim) archivePeriod() time.Duration {
+	return time.Duration(s.ee[0x2d]) * time.Minute
+}
+
+// location returns the console time zone from the EEPROM.
+func (s *Sim) location() *time.Location {
+	var tz data.EETimeZone
+	tz.UnmarshalBinary(s.ee[0x11:0x17])
+
+	return tz.Location()
+}
+
+// archive writes any archive records which are due as of t.  Records are
+// stamped with the time in the console time zone.
+func (s *Sim) archive(t time.Time) {
+	period := s.archivePeriod()
+	for next := s.arcLast.Add(period); !next.After(t); next = next.Add(period) {
+		s.arcLast = next
+		if s.arcStopped {
+			continue
+		}
+
+		s.wander()
+		s.arc[s.arcNext] = data.Archive{
+			Bar:          s.l.Bar.SeaLevel,
+			InHumidity:   s.l.InHumidity,
+			InTemp:       s.l.InTemp,
+			OutHumidity:  s.l.OutHumidity,
+			OutTemp:      s.l.OutTemp,
+			OutTempHi:    s.l.OutTemp,
+			OutTempLow:   s.l.OutTemp,
+			Timestamp:    next.In(s.location()),
+			WindSamples:  int(period / (2500 * time.Millisecond)),
+			WindSpeedAvg: s.l.Wind.Cur.Speed,
+			WindSpeedHi:  s.l.Wind.Cur.Speed,
+		}
+		s.arcNext = (s.arcNext + 1) % simArcRecs
+		if s.arcLen < simArcRecs {
+			s.arcLen++
+		}
+	}
+}
+
+// records returns the archive memory indexes of the records in order from
+// oldest to newest.
+func (s *Sim) records() (idx []int) {
+	first := 0
+	if s.arcLen == simArcRecs {
+		first = s.arcNext
+	}
+	for i := 0; i < s.arcLen; i++ {
+		idx = append(idx, (first+i)%simArcRecs)
+	}
+
+	return
+}
+
+// dmpAft prepares the pages for a DMPAFT download of the records after
+// the one with the 4-byte date and time stamp.  If it isn't the stamp of a
+// record then all records are sent.  Like the console the local time is
+// matched, so the first of any records with the same one is used.
+func (s *Sim) dmpAft(stamp []byte) {
+	idx := s.records()
+	start := 0
+	ts := make([]byte, 4)
+	for i, j := range idx {
+		packet.SetDateTime32(&ts, 0, s.arc[j].Timestamp)
+		if bytes.Equal(ts, stamp) {
+			start = i + 1
+			break
+		}
+	}
+
+	// Pages are sent as they're laid out in memory so the first page may
+	// start with records before the ones requested and, if memory is
+	// full, the last page may end with the oldest records.
+	var dm data.DmpMeta
+	s.pages = nil
+	if start < len(idx) {
+		first := idx[start] / 5
+		dm.FirstPageOffset = idx[start] % 5
+		last := idx[len(idx)-1] / 5
+		for pg := first; ; pg = (pg + 1) % (simArcRecs / 5) {
+			var d data.Dmp
+			copy(d[:], s.arc[pg*5:pg*5+5])
+			p, _ := d.MarshalBinary()
+			p[0] = byte(len(s.pages))
+			packet.SetCrc(&p)
+			s.pages = append(s.pages, p)
+			if pg == last && (len(s.pages) > 1 || dm.FirstPageOffset <= idx[len(idx)-1]%5) {
+				break
+			}
+		}
+	}
+	dm.Pages = len(s.pages)
+	p, _ := dm.MarshalBinary()
+	s.reply(p, true)
+
+	s.page = 0
+	s.state = simDmpStart
+	if dm.Pages == 0 {
+		s.state = simIdle
+	}
+}
+
+// dmpAll prepares the pages for a DMP download of the whole archive
+// memory and sends the first one.
+func (s *Sim) dmpAll() {
+	s.pages = nil
+	for pg := 0; pg < simArcRecs/5; pg++ {
+		var d data.Dmp
+		copy(d[:], s.arc[pg*5:pg*5+5])
+		p, _ := d.MarshalBinary()
+		p[0] = byte(pg)
+		packet.SetCrc(&p)
+		s.pages = append(s.pages, p)
+	}
+
+	s.page = 0
+	s.state = simDmp
+	s.reply(s.pages[0], true)
+}
+
+// dmp processes the response to a DMP page.
+func (s *Sim) dmp(b []byte) {
+	if len(b) != 1 {
+		s.state = simIdle
+		s.command(b)
+		return
+	}
+
+	switch b[0] {
+	case simAck:
+		// Start or next page.
+		if s.state == simDmp {
+			s.page++
+		}
+		s.state = simDmp
+		if s.page >= len(s.pages) {
+			s.state = simIdle
+			return
+		}
+		s.reply(s.pages[s.page], true)
+	case simDmpNak:
+		// Resend page.
+		s.reply(s.pages[s.page], true)
+	default:
+		// Escape or anything else cancels.
+		s.state = simIdle
+	}
+}
+
+// loop queues the next loop packet.
+func (s *Sim) loop() {
+	s.archive(s.now())
+	s.wander()
+
+	// Interleave loop types.
+	s.l.LoopType = s.nextLoopType + 1
+	s.nextLoopType = (s.nextLoopType + 1) % 2
+	s.l.NextArcRec = s.arcNext
+	s.l.Alarms = s.alarms()
+
+	p, _ := s.l.MarshalBinary()
+	s.reply(p, true)
+
+	s.loops--
+	if s.loops < 1 {
+		s.state = simIdle
+	}
+}
+
+// alarms returns the active alarm conditions.  Only the outside
+// temperature thresholds are checked.
+func (s *Sim) alarms() (la data.LoopAlarms) {
+	if v := packet.GetUInt8(s.ee, 0x5a); v != 0xff && s.l.OutTemp < float64(v-90) {
+		la.OutTempLow = true
+	}
+	if v := packet.GetUInt8(s.ee, 0x5b); v != 0xff && s.l.OutTemp > float64(v-90) {
+		la.OutTempHi = true
+	}
+
+	return
+}
+
+// wander makes observation values wander around like they would on a
+// real station.
+func (s *Sim) wander() {
+	s.l.Bar.Altimeter = s.step(s.l.Bar.Altimeter, 0.01)
+	s.l.Bar.SeaLevel = s.step(s.l.Bar.SeaLevel, 0.01)
+	s.l.Bar.Station = s.step(s.l.Bar.Station, 0.01)
+	s.l.OutHumidity = int(s.step(float64(s.l.OutHumidity), 1))
+	s.l.OutTemp = s.step(s.l.OutTemp, 0.5)
+	if s.l.Wind.Cur.Speed = int(s.step(float64(s.l.Wind.Cur.Speed), 1)); s.l.Wind.Cur.Speed < 0 {
+		s.l.Wind.Cur.Speed = 0
+	}
+}
+
+// hiLows returns the record highs and lows from the archive records in
+// memory.  Lows and highs are tracked for the barometer, inside and
+// outside temperature and humidity, and wind speed.
+func (s *Sim) hiLows() (hl data.HiLows) {
+	now := s.now().In(s.location())
+	first := true
+	for _, i := range s.records() {
+		a := s.arc[i]
+		day := a.Timestamp.YearDay() == now.YearDay() && a.Timestamp.Year() == now.Year()
+		month := a.Timestamp.Month() == now.Month() && a.Timestamp.Year() == now.Year()
+		if !month {
+			continue
+		}
+
+		hiLowFloat(&hl.Bar.Day.Hi, &hl.Bar.Day.HiTime, &hl.Bar.Day.Low, &hl.Bar.Day.LowTime,
+			&hl.Bar.Month.Hi, &hl.Bar.Month.Low, &hl.Bar.Year.Hi, &hl.Bar.Year.Low, a.Bar, a.Timestamp, day, first)
+		hiLowFloat(&hl.InTemp.Day.Hi, &hl.InTemp.Day.HiTime, &hl.InTemp.Day.Low, &hl.InTemp.Day.LowTime,
+			&hl.InTemp.Month.Hi, &hl.InTemp.Month.Low, &hl.InTemp.Year.Hi, &hl.InTemp.Year.Low, a.InTemp, a.Timestamp, day, first)
+		hiLowFloat(&hl.OutTemp.Day.Hi, &hl.OutTemp.Day.HiTime, &hl.OutTemp.Day.Low, &hl.OutTemp.Day.LowTime,
+			&hl.OutTemp.Month.Hi, &hl.OutTemp.Month.Low, &hl.OutTemp.Year.Hi, &hl.OutTemp.Year.Low, a.OutTemp, a.Timestamp, day, first)
+		hiLowInt(&hl.InHumidity, a.InHumidity, a.Timestamp, day, first)
+		hiLowInt(&hl.OutHumidity, a.OutHumidity, a.Timestamp, day, first)
+		if day && (first || a.WindSpeedHi > hl.WindSpeed.Day.Hi) {
+			hl.WindSpeed.Day.Hi = a.WindSpeedHi
+			hl.WindSpeed.Day.HiTime = a.Timestamp
+		}
+		if a.WindSpeedHi > hl.WindSpeed.Month.Hi {
+			hl.WindSpeed.Month.Hi = a.WindSpeedHi
+			hl.WindSpeed.Year.Hi = a.WindSpeedHi
+		}
+		first = false
+	}
+
+	return
+}
+
+// hiLowFloat updates float highs and lows with value v at time t.
+func hiLowFloat(dayHi *float64, dayHiTime *time.Time, dayLow *float64, dayLowTime *time.Time,
+	monthHi, monthLow, yearHi, yearLow *float64, v float64, t time.Time, day, first bool) {
+	if day && (*dayHiTime == time.Time{} || v > *dayHi) {
+		*dayHi, *dayHiTime = v, t
+	}
+	if day && (*dayLowTime == time.Time{} || v < *dayLow) {
+		*dayLow, *dayLowTime = v, t
+	}
+	if first || v > *monthHi {
+		*monthHi, *yearHi = v, v
+	}
+	if first || v < *monthLow {
+		*monthLow, *yearLow = v, v
+	}
+}
+
+// hiLowInt updates humidity highs and lows with value v at time t.
+func hiLowInt(h *data.HiLowHumidity, v int, t time.Time, day, first bool) {
+	if day && (h.Day.HiTime == time.Time{} || v > h.Day.Hi) {
+		h.Day.Hi, h.Day.HiTime = v, t
+	}
+	if day && (h.Day.LowTime == time.Time{} || v < h.Day.Low) {
+		h.Day.Low, h.Day.LowTime = v, t
+	}
+	if first || v > h.Month.Hi {
+		h.Month.Hi, h.Year.Hi = v, v
+	}
+	if first || v < h.Month.Low {
+		h.Month.Low, h.Year.Low = v, v
+	}
+}
+
+// simEEPROM returns the EEPROM image of a factory default console with an
+// ISS on transmitter 1 located at Davis Instruments.
+func simEEPROM() []byte {
+	ee := make([]byte, simEESize)
+	for i := range ee {
+		ee[i] = 0xff
+	}
+
+	packet.SetFloat16(&ee, 0x0b, 379)   // Latitude (tenths of a degree)
+	packet.SetFloat16(&ee, 0x0d, -1221) // Longitude (tenths of a degree)
+	packet.SetUInt16(&ee, 0x0f, 50)     // Elevation (ft)
+	packet.SetUInt8(&ee, 0x11, 4)       // Time zone (Pacific)
+	packet.SetUInt8(&ee, 0x12, 0)       // Daylight savings auto
+	packet.SetUInt8(&ee, 0x13, 0)       // Daylight savings off
+	packet.SetUInt16(&ee, 0x14, 0)      // GMT offset
+	packet.SetUInt8(&ee, 0x16, 0)       // Use time zone
+	packet.SetUInt8(&ee, 0x17, 0x01)    // Transmitters to listen to
+	packet.SetUInt8(&ee, 0x18, 0)       // Retransmit off
+	for i := uint(0); i < 8; i++ {
+		// Station list: ISS on 1 and everything else off.
+		t := 0x0a
+		if i == 0 {
+			t = 0x00
+		}
+		packet.SetUInt8(&ee, 0x19+i*2, t)
+		packet.SetUInt8(&ee, 0x19+i*2+1, 0xff)
+	}
+	packet.SetUInt8(&ee, 0x29, 0x00) // Unit bits
+	packet.SetUInt8(&ee, 0x2a, 0xff) // Unit bits complement
+	packet.SetUInt8(&ee, 0x2b, 0x4a) // Setup bits: N, W, large cup, AM
+	packet.SetUInt8(&ee, 0x2c, 1)    // Rain season start
+	packet.SetUInt8(&ee, 0x2d, 5)    // Archive period (minutes)
+	for i := uint(0x32); i < 0x4f; i++ {
+		packet.SetUInt8(&ee, i, 0) // Calibrations
+	}
+
+	return ee
+}
+
+// step takes a value and randomly adds +/- step or zero.
+func (s *Sim) step(v, step float64) float64 {
+	return v + float64(s.rand.Intn(3)-1)*step
 }
@@ -5,6 +5,7 @@
 package weatherlink
 
 import (
+	"context"
 	"encoding/hex"
 	"strconv"
 
@@ -14,27 +15,41 @@ import (
 // GetLoops starts a stream of loop packets and sends them to the
 // event channel. It exits when numLoops is hit, an archive record
 // was written, or a command is pending.
-func (c *Conn) GetLoops(ec chan<- interface{}) (err error) {
+func (c *Conn) GetLoops(ec chan<- interface{}) error {
+	return c.GetLoopsContext(c.brokerContext(), ec)
+}
+
+// GetLoopsContext is like GetLoops but also exits when the context
+// is done.
+func (c *Conn) GetLoopsContext(ctx context.Context, ec chan<- interface{}) (err error) {
 	// The preferred exit condition is sensing a new archive record so
 	// try to get 30 seconds beyond that.
-	numLoops := (int(archInt.Seconds()) + 30) / 2
+	numLoops := (int(c.ArchivePeriod().Seconds()) + 30) / 2
 
 	Info.Printf("Retrieving %d loop packets", numLoops)
 
 	// Start a stream of LOOP1&2 packets, loop through, decode, and
 	// send each one to the loops channel.
-	_, err = c.writeCmd([]byte("LPS 3 "+strconv.Itoa(numLoops)+"\n"), []byte{ack}, 0)
+	_, err = c.writeCmd(ctx, []byte("LPS 3 "+strconv.Itoa(numLoops)+"\n"), []byte{ack}, 0)
 	if err != nil {
 		Error.Printf("LPS command error: %s, aborting", err.Error())
 		return
 	}
+	defer c.watch(ctx)()
 
 	p := make([]byte, 99)
 	var l data.Loop
 	nextArcRec := -1
 	for loopNum := 0; loopNum < numLoops; loopNum++ {
 		_, err = c.d.ReadFull(p)
-		if err != nil {
+		if ctx.Err() != nil {
+			// Stop the LOOP stream so the console is ready for the next
+			// command.
+			Debug.Printf("Loop stream %d/%d cancelled: %s", loopNum, numLoops, ctx.Err())
+			c.softReset()
+			err = ctx.Err()
+			break
+		} else if err != nil {
 			// LOOP stream was interrupted before we received all of the
 			// expected packets.
 			Warn.Printf("Loop stream %d/%d read interrupted: %s, aborting",
@@ -78,7 +93,7 @@ func (c *Conn) GetLoops(ec chan<- interface{}) (err error) {
 		}
 
 		// Loops are low priority so if something else is waiting to run then exit.
-		if len(c.Q) > 0 {
+		if c.pending() > 0 {
 			Debug.Println("Command queue is not empty, cancelling get loops")
 			c.softReset()
 			break
@@ -27,7 +27,8 @@ const (
 // GetBarTrend gets a barometer trend from a given packet at
 // the specified index.
 func GetBarTrend(p []byte, i uint) string {
-	switch GetUInt8(p, i) {
+	// The trend is signed.
+	switch int8(p[i]) {
 	case -60:
 		return FallingRapid
 	case -20:
@@ -66,6 +67,12 @@ func GetDate16(p []byte, i uint) time.Time {
 // GetDateTime32 gets a 4-byte date and time value from a given packet
 // at the specified index.
 func GetDateTime32(p []byte, i uint) time.Time {
+	return GetDateTime32In(p, i, time.Local)
+}
+
+// GetDateTime32In gets a 4-byte date and time value from a given packet
+// at the specified index in the given location.
+func GetDateTime32In(p []byte, i uint, loc *time.Location) time.Time {
 	// The date is stored in the first two bytes as:
 	//
 	//  YYYY YYYM MMMD DDDD
@@ -80,12 +87,18 @@ func GetDateTime32(p []byte, i uint) time.Time {
 	hour := t / 100
 	minute := t % 100
 
-	return time.Date(year, time.Month(month), day, hour, minute, 0, 0, time.Local)
+	return time.Date(year, time.Month(month), day, hour, minute, 0, 0, loc)
 }
 
 // GetDateTime48 gets a 6-byte date and time value from a given packet
 // at the specified index.
 func GetDateTime48(p []byte, i uint) time.Time {
+	return GetDateTime48In(p, i, time.Local)
+}
+
+// GetDateTime48In gets a 6-byte date and time value from a given packet
+// at the specified index in the given location.
+func GetDateTime48In(p []byte, i uint, loc *time.Location) time.Time {
 	second := GetUInt8(p, i)
 	minute := GetUInt8(p, i+1)
 	hour := GetUInt8(p, i+2)
@@ -93,7 +106,7 @@ func GetDateTime48(p []byte, i uint) time.Time {
 	month := GetUInt8(p, i+4)
 	year := 1900 + GetUInt8(p, i+5)
 
-	return time.Date(year, time.Month(month), day, hour, minute, second, 0, time.Local)
+	return time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
 }
 
 // GetFloat16 gets a 2-byte signed two's complement float value from
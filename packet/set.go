@@ -4,7 +4,32 @@
 
 package packet
 
-import "time"
+import (
+	"math"
+	"time"
+)
+
+// SetBarTrend sets a barometer trend in a given packet at the specified
+// index.
+func SetBarTrend(p *[]byte, i uint, v string) {
+	var t int
+	switch v {
+	case FallingRapid:
+		t = -60
+	case FallingSlow:
+		t = -20
+	case Steady:
+		t = 0
+	case RisingSlow:
+		t = 20
+	case RisingRapid:
+		t = 60
+	default:
+		t = 80 // Dash
+	}
+
+	SetUInt8(p, i, t)
+}
 
 // SetCrc sets the last 2-bytes of a given packet to the proper
 // CRC value based on the rest of content.
@@ -14,6 +39,21 @@ func SetCrc(p *[]byte) {
 	(*p)[len(*p)-1] = byte(c)
 }
 
+// SetDate16 sets a 2-byte date (no time) value in a given packet at
+// the specified index.  A zero Time is stored as uninitialized.
+func SetDate16(p *[]byte, i uint, t time.Time) {
+	if t.IsZero() {
+		SetUInt16(p, i, 0xffff)
+		return
+	}
+
+	// The date is stored in the two bytes as:
+	//
+	//  MMMM DDDD DYYY YYYY
+	// 15       8         0
+	SetUInt16(p, i, int(t.Month())<<12+t.Day()<<7+(t.Year()-2000))
+}
+
 // SetDateTime32 sets a 4-byte date and time value in a given packet
 // at the specified index.
 func SetDateTime32(p *[]byte, i uint, t time.Time) {
@@ -45,8 +85,11 @@ func SetDateTime48(p *[]byte, i uint, t time.Time) {
 // SetFloat16 sets a 2-byte signed two's complement float value in
 // a given packet at the specified index.
 func SetFloat16(p *[]byte, i uint, v float64) {
-	(*p)[i] = byte(v)
-	(*p)[i+1] = byte(uint16(v) >> 8)
+	// Round rather than truncate so values survive a round trip, e.g.
+	// 30.01 * 1000 is 30009.99.
+	n := int32(math.Round(v))
+	(*p)[i] = byte(n)
+	(*p)[i+1] = byte(n >> 8)
 }
 
 // SetFloat16_10 sets a 2-byte signed two's complement float value
@@ -55,6 +98,43 @@ func SetFloat16_10(p *[]byte, i uint, v float64) {
 	SetFloat16(p, i, v*10.0)
 }
 
+// SetForecast sets a forecast rule in a given packet at the specified
+// index by finding the rule which produces the forecast string.  If
+// there isn't one then the rule is left unchanged.
+func SetForecast(p *[]byte, i uint, v string) {
+	b := make([]byte, 1)
+	for r := 0; r < 256; r++ {
+		b[0] = byte(r)
+		if GetForecast(b, 0) == v {
+			(*p)[i] = byte(r)
+			return
+		}
+	}
+}
+
+// SetForecastIcons sets forecast icons in a given packet at the
+// specified index.
+func SetForecastIcons(p *[]byte, i uint, icons []string) {
+	var iconBits = []string{ // Bit
+		"Rain",          // 0
+		"Cloud",         // 1
+		"Partly Cloudy", // 2
+		"Sun",           // 3
+		"Snow",          // 4
+	}
+
+	var v int
+	for _, icon := range icons {
+		for j := 0; j < len(iconBits); j++ {
+			if icon == iconBits[j] {
+				v |= 1 << uint(j)
+			}
+		}
+	}
+
+	SetUInt8(p, i, v)
+}
+
 // SetMPH8 sets a 1-byte MPH value in a given packet at the specified
 // index.
 func SetMPH8(p *[]byte, i uint, v int) {
@@ -85,6 +165,35 @@ func SetTemp8(p *[]byte, i uint, v int) {
 	SetUInt8(p, i, v+90)
 }
 
+// SetTime16 sets a 2-byte time (no date) value in a given packet at
+// the specified index.  A zero Time is stored as uninitialized.
+func SetTime16(p *[]byte, i uint, t time.Time) {
+	if t.IsZero() {
+		SetUInt16(p, i, 0xffff)
+		return
+	}
+
+	// The time is stored as: hour * 100 + min
+	SetUInt16(p, i, 100*t.Hour()+t.Minute())
+}
+
+// SetTransStatus sets the transmitters with low batteries in a given
+// packet at the specified index.
+func SetTransStatus(p *[]byte, i uint, low []int) {
+	var v int
+	for _, t := range low {
+		v |= 1 << uint(t-1)
+	}
+
+	SetUInt8(p, i, v)
+}
+
+// SetUFloat8 sets a 1-byte unsigned float value in a given packet
+// at the specified index.
+func SetUFloat8(p *[]byte, i uint, v float64) {
+	(*p)[i] = byte(math.Round(v))
+}
+
 // SetUInt8 sets a 1-byte unsigned integer value in a given packet
 // at the specified index.
 func SetUInt8(p *[]byte, i uint, v int) {
@@ -98,8 +207,20 @@ func SetUInt16(p *[]byte, i uint, v int) {
 	(*p)[i+1] = byte(uint16(v) >> 8)
 }
 
+// SetUVIndex sets an Ultraviolet index value in a given packet at
+// the specified index.
+func SetUVIndex(p *[]byte, i uint, v float64) {
+	SetUFloat8(p, i, v*10.0)
+}
+
 // SetVoltage sets a battery voltage value in a given packet
 // at the specified index.
 func SetVoltage(p *[]byte, i uint, v float64) {
-	SetFloat16(p, i, v*300.0*512.0*100.0)
+	SetFloat16(p, i, v*100.0*512.0/300.0)
+}
+
+// SetWindDir sets a wind direction value in degrees in a given packet
+// at the specified index.
+func SetWindDir(p *[]byte, i uint, v int) {
+	SetUInt8(p, i, int(math.Round(float64(v)/22.5))%16)
 }
@@ -0,0 +1,711 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+// Package proxy implements a console multiplexing proxy so multiple
+// clients can share one station.
+//
+// The proxy holds the single device connection and runs its command
+// broker.  Clients connect to a TCP endpoint which speaks the console
+// protocol.  Loop packets are answered from the live stream, archive
+// downloads from a local cache, and other commands are queued to the
+// broker.
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ebarkie/weatherlink"
+	"github.com/ebarkie/weatherlink/data"
+	"github.com/ebarkie/weatherlink/packet"
+)
+
+// Protocol bytes.
+const (
+	ack    = 0x06 // Acknowledge
+	cancel = 0x18 // Cancel (bad CRC)
+	dmpNak = 0x15 // Not acknowledge (DMP)
+	nak    = 0x21 // Not acknowledge
+)
+
+// Tunables.
+var (
+	// ArchiveSize is the number of archive records cached, which is the
+	// maximum a Vantage Pro 2 console can hold in memory.
+	ArchiveSize = 5 * 512
+
+	// ClientTimeout is how long a client can take to send the rest of a
+	// command, such as the DMPAFT time or a DMP page ACK.
+	ClientTimeout = 1 * time.Minute
+)
+
+// WritePolicy is what the proxy does with client commands that change
+// the console.
+type WritePolicy uint8
+
+// Write policies.
+const (
+	// Refuse answers writes with a NAK.
+	Refuse WritePolicy = iota
+	// Serialize queues writes to the command broker so they run one at
+	// a time between the broker's own commands.
+	Serialize
+)
+
+// Server is a console multiplexing proxy.
+type Server struct {
+	Writes WritePolicy // Policy for client writes
+
+	bc *weatherlink.Conn // Connection running the broker
+	c  weatherlink.Conn  // Copy for queuing commands without racing the broker
+
+	mu   sync.Mutex
+	arcs []data.Archive // Archive record cache, oldest first
+	err  error          // Error which stopped the broker
+}
+
+// New returns a proxy for the console connection.
+func New(c *weatherlink.Conn) *Server {
+	return &Server{bc: c, c: *c}
+}
+
+// ListenAndServe listens on the TCP network address and serves clients
+// until the context is done.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, l)
+}
+
+// Serve starts the command broker and serves clients on the listener
+// until the context is done or the broker stops.  The listener is closed
+// when it returns.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	// Fill the archive cache right away rather than waiting for the
+	// next archive record.
+	select {
+	case s.bc.Q <- weatherlink.GetDmps:
+	default:
+	}
+	ec := s.bc.StartContext(ctx, weatherlink.StdIdle)
+	go func() {
+		s.cache(ctx, ec)
+		stop()
+	}()
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return s.stopErr(ctx, err)
+		}
+
+		go s.serve(ctx, conn)
+	}
+}
+
+// stopErr returns why serving stopped: the broker stopping, the context
+// being done, or the listener failing.
+func (s *Server) stopErr(ctx context.Context, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	} else if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// payload returns the payload of an event, which may be wrapped in an
+// Event envelope.
+func payload(e interface{}) interface{} {
+	if ev, ok := e.(weatherlink.Event); ok {
+		return ev.Payload
+	}
+
+	return e
+}
+
+// cache caches archive records from the event channel until it's
+// closed and then records why the broker stopped.
+func (s *Server) cache(ctx context.Context, ec <-chan interface{}) {
+	var last interface{}
+	for e := range ec {
+		last = payload(e)
+		switch e := last.(type) {
+		case data.Archive:
+			s.mu.Lock()
+			if n := len(s.arcs); n > 0 && !e.Timestamp.After(s.arcs[n-1].Timestamp) {
+				// Already cached.
+				s.mu.Unlock()
+				continue
+			}
+			s.arcs = append(s.arcs, e)
+			if len(s.arcs) > ArchiveSize {
+				s.arcs = s.arcs[len(s.arcs)-ArchiveSize:]
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	// Errors are usually recovered from.  The broker only sends the one
+	// which stopped it right before closing the event channel.
+	if ctx.Err() != nil {
+		return
+	}
+	err, ok := last.(error)
+	if !ok {
+		err = weatherlink.ErrStopped
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// archive returns the cached archive records after t.
+func (s *Server) archive(t time.Time) []data.Archive {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.arcs {
+		if s.arcs[i].Timestamp.After(t) {
+			return append([]data.Archive{}, s.arcs[i:]...)
+		}
+	}
+
+	return nil
+}
+
+// client is a connected client.
+type client struct {
+	s    *Server
+	ctx  context.Context
+	conn net.Conn
+	in   chan []byte // Data from the client, closed when it disconnects
+	buf  []byte      // Data from the client not read yet
+}
+
+// serve serves a client until it disconnects or the context is done.
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	weatherlink.Debug.Printf("Proxy client %s connected", conn.RemoteAddr())
+	defer weatherlink.Debug.Printf("Proxy client %s disconnected", conn.RemoteAddr())
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	cl := &client{s: s, ctx: ctx, conn: conn, in: make(chan []byte)}
+	go func() {
+		defer close(cl.in)
+		for {
+			b := make([]byte, 512)
+			n, err := conn.Read(b)
+			if err != nil {
+				return
+			}
+			select {
+			case cl.in <- b[:n]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		line, err := cl.readLine()
+		if err != nil {
+			return
+		}
+		if err = cl.command(line); err != nil {
+			weatherlink.Debug.Printf("Proxy client %s error: %s", conn.RemoteAddr(), err.Error())
+			return
+		}
+	}
+}
+
+// errTimeout is returned when a client takes too long to send the rest
+// of a command.
+var errTimeout = errors.New("client timeout")
+
+// fill waits for more data from the client.
+func (cl *client) fill(timeout <-chan time.Time) error {
+	select {
+	case b, ok := <-cl.in:
+		if !ok {
+			return net.ErrClosed
+		}
+		cl.buf = append(cl.buf, b...)
+		return nil
+	case <-timeout:
+		return errTimeout
+	case <-cl.ctx.Done():
+		return cl.ctx.Err()
+	}
+}
+
+// readLine reads a command line, without the line feed.
+func (cl *client) readLine() (string, error) {
+	for {
+		if i := strings.IndexByte(string(cl.buf), '\n'); i >= 0 {
+			line := strings.TrimRight(string(cl.buf[:i]), "\r")
+			cl.buf = cl.buf[i+1:]
+			return line, nil
+		}
+		if err := cl.fill(nil); err != nil {
+			return "", err
+		}
+	}
+}
+
+// read reads n bytes.
+func (cl *client) read(n int) ([]byte, error) {
+	timeout := time.After(ClientTimeout)
+	for len(cl.buf) < n {
+		if err := cl.fill(timeout); err != nil {
+			return nil, err
+		}
+	}
+	p := cl.buf[:n]
+	cl.buf = cl.buf[n:]
+
+	return p, nil
+}
+
+// write writes a response.
+func (cl *client) write(p ...[]byte) (err error) {
+	for _, b := range p {
+		if _, err = cl.conn.Write(b); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// command runs a client command.
+func (cl *client) command(line string) (err error) {
+	ok := []byte("\n\rOK\n\r")
+
+	f := strings.Fields(line)
+	if len(f) < 1 {
+		// Wakeup
+		return cl.write([]byte("\n\r"))
+	}
+
+	if strings.HasPrefix(f[0], "BAR=") {
+		return cl.setBarCal(f)
+	}
+
+	var r weatherlink.Result
+	switch f[0] {
+	case "BARDATA":
+		if r, err = cl.s.c.Do(cl.ctx, weatherlink.GetBarData); err != nil {
+			return cl.write([]byte{nak})
+		}
+		p, _ := r.(data.BarData).MarshalText()
+		return cl.write(ok, p)
+	case "CLRALM":
+		return cl.do(weatherlink.ClearAlarms(weatherlink.Confirm), []byte("\n\rOK\n\rDONE\n\r"))
+	case "CLRCAL":
+		return cl.do(weatherlink.ClearCal(weatherlink.Confirm), []byte("\n\rOK\n\rDONE\n\r"))
+	case "CLRGRA":
+		return cl.do(weatherlink.ClearGraphs(weatherlink.Confirm), []byte("\n\rOK\n\rDONE\n\r"))
+	case "CLRHIGHS", "CLRLOWS", "CLRVAR":
+		if len(f) < 2 {
+			return cl.write([]byte{nak})
+		}
+		n, err := strconv.ParseUint(f[1], 10, 8)
+		if err != nil {
+			return cl.write([]byte{nak})
+		}
+		var cmd weatherlink.Command = weatherlink.ClearAccum{Accum: weatherlink.Accum(n), Confirm: weatherlink.Confirm}
+		switch f[0] {
+		case "CLRHIGHS":
+			cmd = weatherlink.ClearHighs{Period: weatherlink.Period(n), Confirm: weatherlink.Confirm}
+		case "CLRLOWS":
+			cmd = weatherlink.ClearLows{Period: weatherlink.Period(n), Confirm: weatherlink.Confirm}
+		}
+		return cl.do(cmd, []byte{ack})
+	case "CLRLOG":
+		return cl.do(weatherlink.ClearLog(weatherlink.Confirm), []byte{ack})
+	case "DMP":
+		return cl.dmpAll(cl.s.archive(time.Time{}))
+	case "DMPAFT":
+		if err = cl.write([]byte{ack}); err != nil {
+			return
+		}
+		var p []byte
+		if p, err = cl.read(6); err != nil {
+			return
+		}
+		var da data.DmpAft
+		if da.UnmarshalBinaryIn(p, cl.s.c.ConsLocation()) != nil {
+			return cl.write([]byte{cancel})
+		}
+		// Like the console, the first record with the same local time is
+		// the one matched.
+		return cl.dmp(cl.s.archive(data.ArcTime(time.Time(da), time.Time{})))
+	case "EEBRD":
+		if len(f) < 3 {
+			return cl.write([]byte{nak})
+		}
+		addr, err1 := strconv.ParseUint(f[1], 16, 16)
+		n, err2 := strconv.ParseUint(f[2], 16, 16)
+		if err1 != nil || err2 != nil {
+			return cl.write([]byte{nak})
+		}
+		r, err = cl.s.c.Do(cl.ctx, weatherlink.ReadEEPROM{Addr: int(addr), Len: int(n)})
+		if err != nil {
+			return cl.write([]byte{nak})
+		}
+		p := append(r.([]byte), 0, 0)
+		packet.SetCrc(&p)
+		return cl.write([]byte{ack}, p)
+	case "EEBWR":
+		if len(f) < 3 {
+			return cl.write([]byte{nak})
+		}
+		addr, err1 := strconv.ParseUint(f[1], 16, 16)
+		n, err2 := strconv.ParseUint(f[2], 16, 16)
+		if err1 != nil || err2 != nil {
+			return cl.write([]byte{nak})
+		}
+		return cl.writeEEPROM(int(addr), int(n))
+	case "GETEE":
+		if r, err = cl.s.c.Do(cl.ctx, weatherlink.GetEEPROM); err != nil {
+			return cl.write([]byte{nak})
+		}
+		p, err := r.(data.EEPROM).MarshalBinary()
+		if err != nil {
+			return cl.write([]byte{nak})
+		}
+		p = append(p, 0, 0)
+		packet.SetCrc(&p)
+		return cl.write([]byte{ack}, p)
+	case "GETTIME":
+		if r, err = cl.s.c.Do(cl.ctx, weatherlink.GetConsTime); err != nil {
+			return cl.write([]byte{nak})
+		}
+		p, _ := data.ConsTime(r.(time.Time).In(cl.s.c.ConsLocation())).MarshalBinary()
+		return cl.write([]byte{ack}, p)
+	case "HILOWS":
+		if r, err = cl.s.c.Do(cl.ctx, weatherlink.GetHiLows); err != nil {
+			return cl.write([]byte{nak})
+		}
+		p, _ := r.(data.HiLows).MarshalBinary()
+		return cl.write([]byte{ack}, p)
+	case "LAMPS":
+		cmd := weatherlink.LampsOff
+		if len(f) > 1 && f[1] == "1" {
+			cmd = weatherlink.LampsOn
+		}
+		if _, err = cl.s.c.Do(cl.ctx, cmd); err != nil {
+			return cl.write([]byte{nak})
+		}
+		return cl.write(ok)
+	case "LOOP":
+		return cl.loops(1, f[1:])
+	case "LPS":
+		if len(f) < 2 {
+			return cl.write([]byte{nak})
+		}
+		mask, _ := strconv.Atoi(f[1])
+		return cl.loops(mask, f[2:])
+	case "NEWSETUP":
+		return cl.do(weatherlink.NewSetup, []byte{ack})
+	case "NVER":
+		if r, err = cl.s.c.Do(cl.ctx, weatherlink.GetFirmVer); err != nil {
+			return cl.write([]byte{nak})
+		}
+		p, _ := data.FirmVer(r.(string)).MarshalText()
+		return cl.write(ok, p)
+	case "PUTET", "PUTRAIN":
+		if len(f) < 2 {
+			return cl.write([]byte{nak})
+		}
+		var cmd weatherlink.Command
+		if f[0] == "PUTET" {
+			var ye data.YearET
+			if ye.UnmarshalText([]byte(f[1])) != nil {
+				return cl.write([]byte{nak})
+			}
+			cmd = weatherlink.PutET(ye)
+		} else {
+			var yr data.YearRain
+			if yr.UnmarshalText([]byte(f[1])) != nil {
+				return cl.write([]byte{nak})
+			}
+			cmd = weatherlink.PutRain(yr)
+		}
+		return cl.do(cmd, []byte{ack})
+	case "RECEIVERS":
+		if r, err = cl.s.c.Do(cl.ctx, weatherlink.GetReceivers); err != nil {
+			return cl.write([]byte{nak})
+		}
+		p, _ := r.(data.Receivers).MarshalBinary()
+		return cl.write(ok, p)
+	case "RXCHECK":
+		if r, err = cl.s.c.Do(cl.ctx, weatherlink.GetRxCheck); err != nil {
+			return cl.write([]byte{nak})
+		}
+		p, _ := r.(data.RxStats).MarshalText()
+		return cl.write(ok, p)
+	case "SETPER":
+		if len(f) < 2 {
+			return cl.write([]byte{nak})
+		}
+		minutes, _ := strconv.Atoi(f[1])
+		return cl.do(weatherlink.SetArchivePeriod(minutes), []byte{ack})
+	case "SETTIME":
+		return cl.setTime()
+	case "START":
+		return cl.do(weatherlink.StartArchiving, ok)
+	case "STOP":
+		return cl.do(weatherlink.StopArchiving, ok)
+	case "TEST":
+		return cl.write([]byte("\n\rTEST\n\r"))
+	case "VER":
+		if r, err = cl.s.c.Do(cl.ctx, weatherlink.GetFirmBuildTime); err != nil {
+			return cl.write([]byte{nak})
+		}
+		p, _ := data.FirmTime(r.(time.Time)).MarshalText()
+		return cl.write(ok, p)
+	default:
+		// Unsupported commands.
+		return cl.write([]byte{nak})
+	}
+}
+
+// dmp sends archive records as download pages after the DMPAFT metadata.
+func (cl *client) dmp(arcs []data.Archive) (err error) {
+	pages := dmpPages(arcs, 0, cl.s.c.ConsLocation())
+	p, _ := data.DmpMeta{Pages: len(pages)}.MarshalBinary()
+	if err = cl.write([]byte{ack}, p); err != nil || len(pages) < 1 {
+		return
+	}
+
+	return cl.sendPages(pages, -1)
+}
+
+// dmpAll sends archive records as all of the pages of archive memory,
+// which unlike DMPAFT start right after the command is acknowledged.
+func (cl *client) dmpAll(arcs []data.Archive) (err error) {
+	// Only the newest records fit once the cache is larger than the
+	// console memory.
+	if n := len(arcs) - arcPages*5; n > 0 {
+		arcs = arcs[n:]
+	}
+	pages := dmpPages(arcs, arcPages, cl.s.c.ConsLocation())
+	if err = cl.write([]byte{ack}, pages[0]); err != nil {
+		return
+	}
+
+	return cl.sendPages(pages, 0)
+}
+
+// arcPages is the number of pages of console archive memory.
+const arcPages = 512
+
+// dmpPages encodes archive records as download pages, padded with
+// unwritten records to at least n pages.  Records are stamped with their
+// time in the console time zone.
+func dmpPages(arcs []data.Archive, n int, loc *time.Location) (pages [][]byte) {
+	for i := 0; i < len(arcs) || len(pages) < n; i += 5 {
+		var d data.Dmp
+		if i < len(arcs) {
+			copy(d[:], arcs[i:])
+		}
+		for j := range d {
+			if !d[j].Timestamp.IsZero() {
+				d[j].Timestamp = d[j].Timestamp.In(loc)
+			}
+		}
+		p, _ := d.MarshalBinary()
+		p[0] = byte(len(pages))
+		packet.SetCrc(&p)
+		pages = append(pages, p)
+	}
+
+	return
+}
+
+// sendPages sends download pages as the client acknowledges them, starting
+// after page i.
+func (cl *client) sendPages(pages [][]byte, i int) (err error) {
+	var p []byte
+	for i < len(pages) {
+		if p, err = cl.read(1); err != nil {
+			return
+		}
+		switch p[0] {
+		case ack:
+			// Start or next page.
+			if i++; i >= len(pages) {
+				return
+			}
+		case dmpNak:
+			// Resend page.
+			if i < 0 {
+				continue
+			}
+		default:
+			// Escape or anything else cancels.
+			return
+		}
+		if err = cl.write(pages[i]); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// loops streams n loop packets from the live stream.  The mask selects
+// LOOP1 (1), LOOP2 (2), or both interleaved (3).  Anything sent by the
+// client stops the stream.
+func (cl *client) loops(mask int, args []string) (err error) {
+	if mask < 1 || mask > 3 || len(args) < 1 {
+		return cl.write([]byte{nak})
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return cl.write([]byte{nak})
+	}
+
+	sub := cl.s.c.Subscribe(weatherlink.LoopEvent, 1, weatherlink.DropOldest)
+	defer sub.Close()
+	if err = cl.write([]byte{ack}); err != nil {
+		return
+	}
+
+	loopType := 1
+	if mask == 2 {
+		loopType = 2
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return weatherlink.ErrStopped
+			}
+			l := payload(e).(data.Loop)
+			l.LoopType = loopType
+			p, _ := l.MarshalBinary()
+			if err = cl.write(p); err != nil {
+				return
+			}
+			if mask == 3 {
+				loopType = 3 - loopType
+			}
+		case _, ok := <-cl.in:
+			if !ok {
+				return net.ErrClosed
+			}
+			// Cancel and, like the console, discard what was sent.
+			return
+		case <-cl.ctx.Done():
+			return cl.ctx.Err()
+		}
+	}
+
+	return
+}
+
+// setBarCal calibrates the barometer from a BAR= command if writes are
+// allowed.
+func (cl *client) setBarCal(f []string) error {
+	bar, err1 := strconv.Atoi(strings.TrimPrefix(f[0], "BAR="))
+	if len(f) < 2 || err1 != nil {
+		return cl.write([]byte{nak})
+	}
+	elev, err2 := strconv.Atoi(f[1])
+	if err2 != nil {
+		return cl.write([]byte{nak})
+	}
+
+	return cl.do(weatherlink.SetBarCal{Bar: float64(bar) / 1000.0, Elev: elev}, []byte("\n\rOK\n\r"))
+}
+
+// setTime sets the console time if writes are allowed.
+func (cl *client) setTime() (err error) {
+	if cl.s.Writes == Refuse {
+		return cl.write([]byte{nak})
+	}
+
+	if err = cl.write([]byte{ack}); err != nil {
+		return
+	}
+	p, err := cl.read(8)
+	if err != nil {
+		return
+	}
+	var ct data.ConsTime
+	if ct.UnmarshalBinaryIn(p, cl.s.c.ConsLocation()) != nil {
+		return cl.write([]byte{cancel})
+	}
+	if _, err = cl.s.c.Do(cl.ctx, weatherlink.SetConsTime(ct)); err != nil {
+		return cl.write([]byte{nak})
+	}
+
+	return cl.write([]byte{ack})
+}
+
+// do runs a command which changes the console if writes are allowed and
+// sends the response if it succeeds.
+func (cl *client) do(cmd weatherlink.Command, resp []byte) (err error) {
+	if cl.s.Writes == Refuse {
+		return cl.write([]byte{nak})
+	}
+
+	if _, err = cl.s.c.Do(cl.ctx, cmd); err != nil {
+		return cl.write([]byte{nak})
+	}
+
+	return cl.write(resp)
+}
+
+// writeEEPROM writes n bytes to the EEPROM starting at addr if writes
+// are allowed.
+func (cl *client) writeEEPROM(addr, n int) (err error) {
+	if cl.s.Writes == Refuse {
+		return cl.write([]byte{nak})
+	}
+
+	if err = cl.write([]byte{ack}); err != nil {
+		return
+	}
+	p, err := cl.read(n + 2)
+	if err != nil {
+		return
+	}
+	if packet.Crc(p) != 0 {
+		return cl.write([]byte{cancel})
+	}
+	if _, err = cl.s.c.Do(cl.ctx, weatherlink.WriteEEPROM{Addr: addr, Data: p[:n]}); err != nil {
+		return cl.write([]byte{nak})
+	}
+
+	return cl.write([]byte{ack})
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ebarkie/weatherlink"
+	"github.com/ebarkie/weatherlink/data"
+	"github.com/ebarkie/weatherlink/internal/device"
+	"github.com/ebarkie/weatherlink/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTime is the simulated console time.
+var testTime = time.Date(2016, time.June, 20, 12, 0, 0, 0, time.Local)
+
+// serve starts a proxy for a simulated console and waits for its archive
+// cache to be filled.  It returns the proxy address.
+func serve(t *testing.T, writes WritePolicy) string {
+	defer func(f time.Duration) { weatherlink.ConsTimeSyncFreq = f }(weatherlink.ConsTimeSyncFreq)
+	weatherlink.ConsTimeSyncFreq = 0
+
+	c, err := weatherlink.DialDevice("sim://", &device.Sim{
+		Clock:     func() time.Time { return testTime },
+		LoopDelay: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := New(&c)
+	s.Writes = writes
+	go s.Serve(ctx, l)
+
+	for i := 0; len(s.archive(time.Time{})) < 24*12; i++ {
+		if i > 500 {
+			t.Fatal("Archive cache not filled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return l.Addr().String()
+}
+
+// cmd sends a raw command to the proxy and reads n response bytes.
+func cmd(t *testing.T, conn net.Conn, p []byte, n int) []byte {
+	if _, err := conn.Write(p); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, n)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestClients(t *testing.T) {
+	a := assert.New(t)
+
+	addr := serve(t, Refuse)
+
+	// Archive downloads and loops for several clients at once.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		c, err := weatherlink.Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ec := make(chan interface{}, 5*512)
+			lastRec, err := c.GetDmps(ec, testTime.Add(-1*time.Hour))
+			a.Nil(err)
+			a.Equal(testTime.UTC(), lastRec)
+			a.Equal(12, len(ec))
+
+			ct, err := c.GetConsTime()
+			a.Nil(err)
+			a.True(testTime.Equal(ct), "Console time")
+
+			rcv, err := c.GetReceivers()
+			a.Nil(err)
+			a.Equal(data.Receivers{1}, rcv)
+		}()
+	}
+	wg.Wait()
+
+	// Loops from the live stream, interleaved like the console does.
+	c, err := weatherlink.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ec := make(chan interface{}, 5*512)
+	a.Nil(c.GetLoops(ec))
+	a.True(len(ec) > 1)
+	l1, l2 := (<-ec).(data.Loop), (<-ec).(data.Loop)
+	a.NotEqual(l1.LoopType, l2.LoopType)
+
+	// A full dump of the archive cache.
+	ec = make(chan interface{}, 5*512)
+	n, err := c.DumpArchive(ec, nil)
+	a.Nil(err)
+	a.Equal(24*12, n)
+
+	// The EEPROM can be read even though writes are refused.
+	ec = make(chan interface{}, 1)
+	a.Nil(c.GetEEPROM(ec))
+	ee := (<-ec).(data.EEPROM)
+	a.Equal(5, ee.ArchivePeriod)
+	a.Equal(50, ee.Elev)
+	p, err := c.ReadEEPROMRange(0x0f, 2)
+	a.Nil(err)
+	a.Equal([]byte{50, 0}, p)
+}
+
+func TestWrites(t *testing.T) {
+	a := assert.New(t)
+
+	set := time.Date(2016, time.June, 21, 8, 30, 0, 0, time.Local)
+	p, _ := data.ConsTime(set).MarshalBinary()
+
+	conn, err := net.Dial("tcp", serve(t, Refuse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	a.Equal([]byte{nak}, cmd(t, conn, []byte("SETTIME\n"), 1))
+	a.Equal([]byte{nak}, cmd(t, conn, []byte("EEBWR 2C 01\n"), 1))
+	a.Equal([]byte{nak}, cmd(t, conn, []byte("CLRALM\n"), 1))
+	a.Equal([]byte{nak}, cmd(t, conn, []byte("CLRHIGHS 0\n"), 1))
+	a.Equal([]byte{nak}, cmd(t, conn, []byte("PUTRAIN 2483\n"), 1))
+	a.Equal([]byte{nak}, cmd(t, conn, []byte("STOP\n"), 1))
+
+	conn, err = net.Dial("tcp", serve(t, Serialize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("SETTIME\n"), 1))
+	a.Equal([]byte{ack}, cmd(t, conn, p, 1))
+	p = cmd(t, conn, []byte("GETTIME\n"), 9)
+	a.Equal(byte(ack), p[0])
+	var ct data.ConsTime
+	a.Nil(ct.UnmarshalBinary(p[1:]))
+	a.Equal(set, time.Time(ct))
+
+	// An EEPROM write with a bad CRC is cancelled and a good one is
+	// written.
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("EEBWR 2C 01\n"), 1))
+	a.Equal([]byte{cancel}, cmd(t, conn, []byte{7, 0, 0}, 1))
+	p = []byte{7, 0, 0}
+	packet.SetCrc(&p)
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("EEBWR 2C 01\n"), 1))
+	a.Equal([]byte{ack}, cmd(t, conn, p, 1))
+	a.Equal(append([]byte{ack}, p...), cmd(t, conn, []byte("EEBRD 2C 01\n"), 4))
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("NEWSETUP\n"), 1))
+
+	// The barometer is calibrated and the calibration data is read back.
+	ok := []byte("\n\rOK\n\r")
+	a.Equal(ok, cmd(t, conn, []byte("BAR=0 620\n"), len(ok)))
+	p = cmd(t, conn, []byte("BARDATA\n"), len(ok))
+	a.Equal(ok, p)
+	for n := 0; n < 9; {
+		p = append(p, cmd(t, conn, nil, 1)...)
+		if strings.HasSuffix(string(p), "\n\r") {
+			n++
+		}
+	}
+	var bd data.BarData
+	a.Nil(bd.UnmarshalText(p[len(ok):]))
+	a.Equal(620, bd.Elev)
+
+	done := []byte("\n\rOK\n\rDONE\n\r")
+	a.Equal(done, cmd(t, conn, []byte("CLRALM\n"), len(done)))
+	a.Equal(done, cmd(t, conn, []byte("CLRGRA\n"), len(done)))
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("CLRHIGHS 1\n"), 1))
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("CLRLOWS 2\n"), 1))
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("CLRVAR 13\n"), 1))
+	a.Equal([]byte{nak}, cmd(t, conn, []byte("CLRVAR 99\n"), 1))
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("PUTRAIN 2483\n"), 1))
+	a.Equal([]byte{ack}, cmd(t, conn, []byte("PUTET 1250\n"), 1))
+	a.Equal([]byte{nak}, cmd(t, conn, []byte("PUTET -1\n"), 1))
+	a.Equal(ok, cmd(t, conn, []byte("STOP\n"), len(ok)))
+	a.Equal(ok, cmd(t, conn, []byte("START\n"), len(ok)))
+}
+
+func TestStopErr(t *testing.T) {
+	a := assert.New(t)
+
+	events := func(e ...interface{}) <-chan interface{} {
+		ec := make(chan interface{}, len(e))
+		for _, v := range e {
+			ec <- v
+		}
+		close(ec)
+		return ec
+	}
+	ctx := context.Background()
+	listen := io.ErrClosedPipe
+
+	// Errors which were recovered from aren't why the broker stopped.
+	s := &Server{}
+	s.cache(ctx, events(weatherlink.ErrCmdFailed, data.Loop{}))
+	a.Equal(weatherlink.ErrStopped, s.stopErr(ctx, listen))
+
+	s = &Server{}
+	s.cache(ctx, events(weatherlink.ErrCmdFailed, data.Loop{}, weatherlink.ErrRetriesExhausted))
+	a.Equal(weatherlink.ErrRetriesExhausted, s.stopErr(ctx, listen))
+
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	s = &Server{}
+	s.cache(done, events(weatherlink.ErrCmdFailed))
+	a.Equal(context.Canceled, s.stopErr(done, listen))
+	a.Equal(listen, s.stopErr(ctx, listen))
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// maxRetryDelay is the maximum delay between retries when a policy
+// doesn't set one.  The delay stops doubling there rather than overflowing.
+const maxRetryDelay = 1 * time.Hour
+
+// RetryPolicy controls how failed operations are retried.  The delay
+// before each retry starts at InitialDelay and doubles up to MaxDelay.
+type RetryPolicy struct {
+	InitialDelay time.Duration // Delay before the first retry
+	MaxDelay     time.Duration // Maximum delay between retries, 1 hour if 0
+	Jitter       float64       // Fraction of the delay to randomize by, 0-1
+	MaxAttempts  int           // Maximum attempts or 0 for no limit
+}
+
+// exhausted returns true if attempt is beyond the maximum attempts.
+func (p RetryPolicy) exhausted(attempt int) bool {
+	return p.MaxAttempts > 0 && attempt > p.MaxAttempts
+}
+
+// delay returns the delay before retry attempt n, starting at 1.
+func (p RetryPolicy) delay(n int) time.Duration {
+	limit := p.MaxDelay
+	if limit <= 0 {
+		limit = maxRetryDelay
+	}
+
+	d := p.InitialDelay
+	for i := 1; i < n && d > 0; i++ {
+		d *= 2
+		if d >= limit {
+			d = limit
+			break
+		}
+	}
+
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+
+	return d
+}
+
+// wait sleeps for the delay before retry attempt n.  It returns early
+// with the context's error if it's done.
+func (p RetryPolicy) wait(ctx context.Context, n int) error {
+	d := p.delay(n)
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	Debug.Printf("Waiting %s before retry attempt %d", d, n)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"context"
+
+	"github.com/ebarkie/weatherlink/data"
+)
+
+// GetRxCheck gets the console reception diagnostics.
+func (c Conn) GetRxCheck() (data.RxStats, error) {
+	return c.getRxCheck(c.brokerContext())
+}
+
+// getRxCheck gets the console reception diagnostics.
+func (c Conn) getRxCheck(ctx context.Context) (rs data.RxStats, err error) {
+	_, err = c.writeCmd(ctx, []byte("RXCHECK\n"), []byte("\n\rOK\n\r"), 0)
+	if err != nil {
+		return
+	}
+
+	var p []byte
+	p, err = c.readLines(ctx, 1)
+	if err != nil {
+		return
+	}
+
+	err = rs.UnmarshalText(p)
+
+	return
+}
+
+// GetReceivers gets the IDs of the transmitters the console receives.
+func (c Conn) GetReceivers() (data.Receivers, error) {
+	return c.getReceivers(c.brokerContext())
+}
+
+// getReceivers gets the IDs of the transmitters the console receives.
+func (c Conn) getReceivers(ctx context.Context) (r data.Receivers, err error) {
+	p, err := c.writeCmd(ctx, []byte("RECEIVERS\n"), []byte("\n\rOK\n\r"), 1)
+	if err != nil {
+		return
+	}
+
+	err = r.UnmarshalBinary(p)
+
+	return
+}
+
+// pollRx gets the reception diagnostics and the transmitters received and
+// sends them to the event channel.
+func (c Conn) pollRx(ctx context.Context, ec chan<- interface{}) error {
+	rs, err := c.getRxCheck(ctx)
+	if err != nil {
+		return err
+	}
+	r, err := c.getReceivers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err = emit(ctx, ec, rs); err != nil {
+		return err
+	}
+
+	return emit(ctx, ec, r)
+}
+
+// emit sends an event to the event channel unless the context is done
+// first.
+func emit(ctx context.Context, ec chan<- interface{}, e interface{}) error {
+	select {
+	case ec <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"context"
+	"fmt"
+)
+
+// State is the state of the connection to the station.
+type State uint32
+
+// Connection states.
+const (
+	Connected     State = iota // Connected and ready for commands
+	SoftReset                  // Trying to recover with a test command
+	HardReset                  // Trying to recover by reconnecting
+	Reconnecting               // Reopening the device
+	Disconnected               // Device is closed or couldn't be opened
+	CommandFailed              // Last command failed
+)
+
+func (s State) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case SoftReset:
+		return "soft-reset"
+	case HardReset:
+		return "hard-reset"
+	case Reconnecting:
+		return "reconnecting"
+	case Disconnected:
+		return "disconnected"
+	case CommandFailed:
+		return "command failed"
+	}
+
+	return fmt.Sprintf("State(%d)", uint32(s))
+}
+
+// StateChange is the event the command broker sends to StateEvent
+// subscribers when the connection state changes.
+type StateChange struct {
+	State   State // New state
+	Err     error // Error which triggered the change, if any
+	Attempt int   // Consecutive recovery attempt number, 0 if not recovering
+}
+
+// State returns the current connection state.
+func (c Conn) State() State {
+	return State(c.state.Load())
+}
+
+// setState changes the connection state and sends a StateChange to the
+// event channel.
+func (c Conn) setState(ctx context.Context, ec chan<- interface{}, sc StateChange) {
+	c.state.Store(uint32(sc.State))
+	Debug.Printf("Connection state %s (attempt %d)", sc.State, sc.Attempt)
+
+	select {
+	case ec <- sc:
+	case <-ctx.Done():
+	}
+}
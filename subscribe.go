@@ -0,0 +1,270 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ebarkie/weatherlink/data"
+)
+
+// EventKind is a bitmask of event types used to filter subscriptions.
+type EventKind uint
+
+// Event kinds.
+const (
+	LoopEvent      EventKind = 1 << iota // data.Loop
+	ArchiveEvent                         // data.Archive
+	EEPROMEvent                          // data.EEPROM
+	HiLowsEvent                          // data.HiLows
+	StateEvent                           // StateChange
+	ErrorEvent                           // error
+	RxCheckEvent                         // data.RxStats
+	ReceiversEvent                       // data.Receivers
+	ProgressEvent                        // DmpProgress
+
+	AllEvents = ^EventKind(0) // Everything, including events of unknown type
+)
+
+// lossless are the kinds of events which wait for room in the event
+// channel returned by Start rather than being discarded.  They can't be
+// read again, or in the case of errors explain why the broker stopped.
+const lossless = ArchiveEvent | EEPROMEvent | HiLowsEvent | ErrorEvent
+
+// kindOf returns the kind of an event or 0 if it's unknown.
+func kindOf(e interface{}) EventKind {
+	switch e.(type) {
+	case data.Loop:
+		return LoopEvent
+	case data.Archive:
+		return ArchiveEvent
+	case data.EEPROM:
+		return EEPROMEvent
+	case data.HiLows:
+		return HiLowsEvent
+	case data.RxStats:
+		return RxCheckEvent
+	case data.Receivers:
+		return ReceiversEvent
+	case DmpProgress:
+		return ProgressEvent
+	case StateChange:
+		return StateEvent
+	case error:
+		return ErrorEvent
+	}
+
+	return 0
+}
+
+// Event is the envelope events are wrapped in when Conn.Envelope is
+// enabled.
+type Event struct {
+	Station  string      // Address of the station the event came from
+	Received time.Time   // Time the event was received from the station
+	Seq      uint64      // Sequence number, increasing by one per event
+	Kind     EventKind   // Kind of payload or 0 if it's unknown
+	Payload  interface{} // data.Loop, data.Archive, etc.
+}
+
+// Policy is what a subscription does with an event when its channel
+// is full.
+type Policy uint8
+
+// Backpressure policies.
+const (
+	// DropOldest discards the oldest buffered event to make room.
+	DropOldest Policy = iota
+	// DropNewest discards the new event.
+	DropNewest
+	// Block waits for the subscriber to make room.  This stalls event
+	// delivery to all other subscribers and, once the broker's own
+	// buffer is full, the broker itself so it should be reserved for
+	// consumers which can't lose events.
+	Block
+)
+
+// Subscription is a filtered stream of events from the command broker.
+type Subscription struct {
+	C <-chan interface{} // Event channel
+
+	c       chan interface{}
+	filter  EventKind
+	policy  Policy
+	dropped atomic.Uint64
+
+	h    *hub
+	done chan struct{}
+	once sync.Once
+}
+
+// Dropped returns the number of events discarded because the
+// subscription's channel was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes the event channel.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.h.mu.Lock()
+		defer s.h.mu.Unlock()
+		if _, ok := s.h.subs[s]; ok {
+			delete(s.h.subs, s)
+			close(s.c)
+		}
+	})
+}
+
+// send delivers an event of kind k according to the subscription's
+// filter and policy.
+func (s *Subscription) send(k EventKind, e interface{}) {
+	if s.filter != AllEvents && k&s.filter == 0 {
+		return
+	}
+
+	switch s.policy {
+	case Block:
+		select {
+		case s.c <- e:
+		case <-s.done:
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.c <- e:
+				return
+			default:
+			}
+			select {
+			case <-s.c:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// hub holds the subscriptions of a connection.  It's shared between
+// copies of Conn.
+type hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+
+	seq atomic.Uint64 // Last event sequence number
+}
+
+// newHub returns an empty hub.
+func newHub() *hub {
+	return &hub{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscribe returns a subscription to command broker events matching
+// filter.  Its channel is buffered to bufferSize events, at least 1, and,
+// when full, policy determines what's discarded.  Subscriptions are closed
+// when the command broker stops.
+func (c Conn) Subscribe(filter EventKind, bufferSize int, policy Policy) *Subscription {
+	// An unbuffered channel has no oldest event to drop.
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	ch := make(chan interface{}, bufferSize)
+	s := &Subscription{
+		C:      ch,
+		c:      ch,
+		filter: filter,
+		policy: policy,
+		h:      c.subs,
+		done:   make(chan struct{}),
+	}
+
+	c.subs.mu.Lock()
+	c.subs.subs[s] = struct{}{}
+	c.subs.mu.Unlock()
+
+	return s
+}
+
+// received is an event and the time it was received from the station.
+type received struct {
+	t time.Time
+	e interface{}
+}
+
+// stamp timestamps events as the broker sends them and queues them for
+// dispatch.  Since in is unbuffered an event is timestamped when it's
+// sent, no matter how many are queued.  When in is closed q is closed.
+func stamp(in <-chan interface{}, q chan<- received) {
+	defer close(q)
+
+	for e := range in {
+		q <- received{t: time.Now(), e: e}
+	}
+}
+
+// dispatch fans events out from the broker to the event channel returned
+// by Start and to all subscriptions, wrapping them in an Event first if
+// envelopes are enabled.  State changes are only sent to subscriptions.
+// Lossless events wait for room in the event channel, like GetDmps always
+// has, but others which don't fit are discarded so an event channel which
+// isn't read doesn't hold up the subscriptions.  When q is closed the
+// event channel and all subscriptions are closed.
+func (c Conn) dispatch(q <-chan received, ec chan<- interface{}) {
+	h := c.subs
+	defer close(ec)
+	defer h.closeAll()
+
+	for r := range q {
+		e := r.e
+		k := kindOf(e)
+		if c.Envelope {
+			e = Event{
+				Station:  c.addr,
+				Received: r.t,
+				Seq:      h.seq.Add(1),
+				Kind:     k,
+				Payload:  e,
+			}
+		}
+
+		switch {
+		case k == StateEvent:
+		case k&lossless != 0:
+			ec <- e
+		default:
+			select {
+			case ec <- e:
+			default:
+				Warn.Printf("Event channel is full, discarding %T", e)
+			}
+		}
+
+		h.mu.Lock()
+		for s := range h.subs {
+			s.send(k, e)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// closeAll closes and removes all subscriptions.
+func (h *hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
@@ -8,10 +8,11 @@ package weatherlink
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
-	"io"
-	"strings"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/ebarkie/weatherlink/internal/device"
@@ -25,55 +26,108 @@ const (
 	nak = 0x21 // Not acknowledge
 )
 
-type cmd uint8
-
-// Commands.
-const (
-	GetDmps cmd = iota
-	GetEEPROM
-	GetHiLows
-	GetLoops
-	LampsOff
-	LampsOn
-	Stop
-	SyncConsTime
-)
+// cmdAttempts is how many times a command which gets a bad response is
+// tried when the retry policy doesn't say.
+const cmdAttempts = 3
 
 // Errors.
 var (
-	ErrCmdFailed = errors.New("command failed")
+	ErrCmdFailed        = errors.New("command failed")
+	ErrInvalidArg       = errors.New("invalid argument")
+	ErrNotConfirmed     = errors.New("command not confirmed")
+	ErrNotSupported     = device.ErrNotSupported
+	ErrRetriesExhausted = errors.New("retry attempts exhausted")
+	ErrStopped          = errors.New("command broker stopped")
+	ErrUnknownDevice    = errors.New("unknown device scheme")
+	ErrVerifyFailed     = errors.New("write verification failed")
 )
 
 // Tunables.
 var (
+	// ConsTimeSyncFreq is how often the command broker syncs the console
+	// time, starting when it's started.  Zero disables syncing.
 	ConsTimeSyncFreq = 24 * time.Hour
-)
 
-// dev is an interface for the protocol to use to perform basic I/O
-// operations with different Weatherlink devices.
-type dev interface {
-	io.ReadWriteCloser
-	Dial(addr string) error
-	Flush() error
-	ReadFull(buf []byte) (n int, err error)
-}
+	// RxCheckFreq is how often the command broker polls the reception
+	// diagnostics and the transmitters received, starting when it's
+	// started, and sends them as events.  Zero disables polling.
+	RxCheckFreq time.Duration
+
+	// DefaultCmdRetry is the retry policy for commands which get a bad
+	// response.
+	DefaultCmdRetry = RetryPolicy{MaxAttempts: cmdAttempts}
+	// DefaultResetRetry is the retry policy for hard resets.
+	DefaultResetRetry = RetryPolicy{
+		InitialDelay: 1 * time.Second,
+		MaxDelay:     1 * time.Minute,
+		Jitter:       0.2,
+	}
+)
 
 // Conn holds the weatherlink connnection context.
 type Conn struct {
-	addr string // Device address
-	d    dev    // Device interface (IP, serial(/USB), or simulator)
+	addr    string // Station address
+	devAddr string // Device address
+	d       Device // Device interface (IP, serial(/USB), simulator, etc.)
+
+	LastDmp        time.Time      // Time of the last downloaded archive record
+	NewArcRec      bool           // Indicates a new archive record is available
+	Envelope       bool           // Wrap events in an Event envelope (set before Start)
+	ProgressEvents bool           // Send DmpProgress events during archive downloads
+	ConsLoc        *time.Location // Console time zone, read from the EEPROM if nil
+
+	CmdRetry   RetryPolicy // Command retry policy, 3 attempts if MaxAttempts is 0
+	ResetRetry RetryPolicy // Hard reset retry policy
+
+	Q chan Command // Command queue
+
+	ctx       context.Context                // Command broker context
+	req       chan request                   // Request queue for commands run by Do
+	subs      *hub                           // Event subscriptions
+	state     *atomic.Uint32                 // Connection state
+	arcPeriod *atomic.Int64                  // Archive period
+	stopped   *atomic.Pointer[chan struct{}] // Closed when the command broker isn't running
+	eeLoc     *atomic.Pointer[time.Location] // Time zone from the EEPROM
+}
 
-	LastDmp   time.Time // Time of the last downloaded archive record
-	NewArcRec bool      // Indicates a new archive record is available
+// Dial establishes the weatherlink connection.  The address is either a
+// URL with a registered device scheme, such as tcp://host:port,
+// serial:///dev/ttyUSB0, or sim://, or a plain TCP/IP host:port or
+// serial device path.
+func Dial(addr string) (c Conn, err error) {
+	d, devAddr, err := newDevice(addr)
+	if err != nil {
+		return
+	}
 
-	Q chan cmd // Command queue
+	return dial(addr, devAddr, d)
 }
 
-// Dial establishes the weatherlink connection.
-func Dial(addr string) (c Conn, err error) {
-	c.Q = make(chan cmd, 1)
+// DialDevice establishes the weatherlink connection using the provided
+// device, which is dialed with addr.
+func DialDevice(addr string, d Device) (Conn, error) {
+	return dial(addr, addr, d)
+}
+
+// dial initializes the connection state and opens the device.
+func dial(addr, devAddr string, d Device) (c Conn, err error) {
+	c.Q = make(chan Command, 1)
+	c.req = make(chan request, 1)
+	c.stopped = new(atomic.Pointer[chan struct{}])
+	stopped := make(chan struct{})
+	close(stopped)
+	c.stopped.Store(&stopped)
+	c.subs = newHub()
+	c.state = new(atomic.Uint32)
+	c.arcPeriod = new(atomic.Int64)
+	c.arcPeriod.Store(int64(defaultArcPeriod))
+	c.eeLoc = new(atomic.Pointer[time.Location])
+	c.CmdRetry = DefaultCmdRetry
+	c.ResetRetry = DefaultResetRetry
 
 	c.addr = addr
+	c.devAddr = devAddr
+	c.d = d
 	err = c.open()
 
 	return
@@ -82,19 +136,25 @@ func Dial(addr string) (c Conn, err error) {
 // open makes the connection to the weatherlink device.  It is separate
 // from Dial() so it can be used as a reconnect during hard resets without
 // losing state.
+//
+// Once open the archive period and time zone are read since the console
+// may have been reconfigured.  A failure is only logged so the device is
+// still usable.
 func (c *Conn) open() (err error) {
-	const timeout = 6 * time.Second
-
-	Trace.Printf("Opening device %s with a %s timeout", c.addr, timeout)
-	switch {
-	case c.addr == "/dev/null":
-		c.d = &device.Sim{}
-	case strings.HasPrefix(c.addr, "/dev/"):
-		c.d = &device.Serial{Timeout: timeout}
-	default:
-		c.d = &device.IP{Timeout: timeout}
+	Trace.Printf("Opening device %s", c.addr)
+	err = c.d.Dial(c.devAddr)
+	if err != nil {
+		c.state.Store(uint32(Disconnected))
+		return
+	}
+	c.state.Store(uint32(Connected))
+
+	if err := c.readArchivePeriod(c.brokerContext()); err != nil {
+		Warn.Printf("Archive period read error: %s, using %s", err.Error(), c.ArchivePeriod())
+	}
+	if err := c.readTimeZone(c.brokerContext()); err != nil {
+		Warn.Printf("Time zone read error: %s, using %s", err.Error(), c.ConsLocation())
 	}
-	err = c.d.Dial(c.addr)
 
 	return
 }
@@ -102,9 +162,43 @@ func (c *Conn) open() (err error) {
 // Close closes the weatherlink connection.
 func (c Conn) Close() error {
 	Trace.Printf("Closing device %s", c.addr)
+	c.state.Store(uint32(Disconnected))
 	return c.d.Close()
 }
 
+// brokerContext returns the command broker context or, if the broker
+// was not started with one, a background context.
+func (c Conn) brokerContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// watch interrupts a blocking device read when the context is done.  Only
+// devices which support read deadlines can be interrupted, others will
+// return when their own timeout triggers.  The returned function must be
+// called to release the watcher.
+func (c Conn) watch(ctx context.Context) (release func()) {
+	dl, ok := c.d.(interface{ SetReadDeadline(time.Time) error })
+	if !ok || ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			Trace.Printf("Interrupting device %s read: %s", c.addr, ctx.Err())
+			dl.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
+
 // softReset tries to get the weatherlink device to abort the current command
 // and get into a ready state.  It's usually used to interrupt LPS or DMPAFT
 // commands.
@@ -117,16 +211,16 @@ func (c Conn) softReset() {
 }
 
 // test sends a test command.
-func (c Conn) test() (err error) {
-	_, err = c.writeCmd([]byte("TEST\n"), []byte("\n\rTEST\n\r"), 0)
+func (c Conn) test(ctx context.Context) (err error) {
+	_, err = c.writeCmd(ctx, []byte("TEST\n"), []byte("\n\rTEST\n\r"), 0)
 
 	return
 }
 
 // writeCmd runs a command and requires an acknowledgement response.  If n > 0
 // then a Packet of that length will be read after the acknowledgement.
-func (c Conn) writeCmd(cmd []byte, cmdAck []byte, n int) (p []byte, err error) {
-	const retries = 3
+func (c Conn) writeCmd(ctx context.Context, cmd []byte, cmdAck []byte, n int) (p []byte, err error) {
+	defer c.watch(ctx)()
 
 	// Determine what to print when showing the command in debug mode.  If it
 	// ends with a line feed it's probably printable.
@@ -135,9 +229,23 @@ func (c Conn) writeCmd(cmd []byte, cmdAck []byte, n int) (p []byte, err error) {
 		cmdStr = "[bytes]"
 	}
 
+	// Commands are never retried forever, a dead device is left to the
+	// command broker's resets.
+	retry := c.CmdRetry
+	if retry.MaxAttempts < 1 {
+		retry.MaxAttempts = cmdAttempts
+	}
+
 	resp := make([]byte, len(cmdAck))
 	acked := false
-	for tryNum := 0; tryNum < retries; tryNum++ {
+	for tryNum := 1; !retry.exhausted(tryNum); tryNum++ {
+		if tryNum > 1 {
+			retry.wait(ctx, tryNum-1)
+		}
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
 		Trace.Printf("Command\n%s", hex.Dump(cmd))
 		c.d.Write(cmd)
 
@@ -149,7 +257,7 @@ func (c Conn) writeCmd(cmd []byte, cmdAck []byte, n int) (p []byte, err error) {
 			Trace.Printf("Expected ack\n%s", hex.Dump(cmdAck))
 			Trace.Printf("Actual ack\n%s", hex.Dump(resp))
 			Warn.Printf("Command '%s' bad response, retrying (%d/%d)",
-				cmdStr, tryNum+1, retries)
+				cmdStr, tryNum, retry.MaxAttempts)
 			c.softReset()
 		}
 	}
@@ -169,11 +277,81 @@ func (c Conn) writeCmd(cmd []byte, cmdAck []byte, n int) (p []byte, err error) {
 
 	p = make([]byte, n)
 	_, err = c.d.ReadFull(p)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
 	Trace.Printf("Packet\n%s", hex.Dump(p))
 
 	return
 }
 
+// readLines reads n lines of text which follow a command acknowledgement.
+// Each line ends with a line feed and carriage return.
+func (c Conn) readLines(ctx context.Context, n int) (p []byte, err error) {
+	defer c.watch(ctx)()
+
+	b := make([]byte, 1)
+	for lines := 0; lines < n; {
+		if _, err = c.d.ReadFull(b); err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			return
+		}
+		p = append(p, b[0])
+		if bytes.HasSuffix(p, []byte{lf, cr}) {
+			lines++
+		}
+	}
+	Trace.Printf("Packet\n%s", hex.Dump(p))
+
+	return
+}
+
+// writeCmdDone runs a command which takes a while to complete and waits
+// for the console to send DONE when it's finished.  The manual isn't
+// consistent about whether the OK the console answers with first is
+// preceded by a line feed and carriage return so either is accepted.
+func (c Conn) writeCmdDone(ctx context.Context, cmd []byte) (err error) {
+	// Time to wait for the command to complete.
+	const doneTime = 1 * time.Minute
+	// Time to wait before reading again when nothing was read.
+	const pollTime = 10 * time.Millisecond
+
+	defer c.watch(ctx)()
+	cmdStr := string(cmd[0 : len(cmd)-1])
+
+	Trace.Printf("Command\n%s", hex.Dump(cmd))
+	c.d.Write(cmd)
+
+	var p []byte
+	b := make([]byte, 1)
+	for timeout := time.Now().Add(doneTime); time.Now().Before(timeout); {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if n, _ := c.d.ReadFull(b); n < 1 {
+			time.Sleep(pollTime)
+			continue
+		}
+		p = append(p, b[0])
+
+		resp := bytes.TrimLeft(p, "\n\r")
+		if len(resp) >= 4 && !bytes.HasPrefix(resp, []byte("OK\n\r")) {
+			Trace.Printf("Actual response\n%s", hex.Dump(p))
+			Error.Printf("Command '%s' bad response", cmdStr)
+			return ErrCmdFailed
+		}
+		if bytes.HasSuffix(resp, []byte("DONE\n\r")) {
+			Debug.Printf("Command '%s' successful", cmdStr)
+			return
+		}
+	}
+	Error.Printf("Command '%s' did not complete", cmdStr)
+
+	return ErrCmdFailed
+}
+
 // Idler is the idle function the command broker executes when
 // there are no pending commands in the queue.
 type Idler func(*Conn, chan<- interface{}) error
@@ -194,59 +372,139 @@ func StdIdle(c *Conn, ec chan<- interface{}) (err error) {
 // Start starts the command broker.  If no commands are pending it runs
 // the idler.
 func (c *Conn) Start(idle Idler) <-chan interface{} {
+	return c.StartContext(context.Background(), idle)
+}
+
+// StartContext starts the command broker with a context.  If no commands
+// are pending it runs the idler.  When the context is done any in-progress
+// command is aborted, the broker stops, and the event channel is closed.
+//
+// Failures are recovered from with a soft-reset and then hard-resets
+// following the ResetRetry policy.  If its attempts are exhausted an error
+// wrapping ErrRetriesExhausted is sent to the event channel and the broker
+// stops.
+//
+// Archive records, EEPROM settings, highs and lows, and errors wait for
+// room in the returned event channel so none are lost to a slow reader.
+// Other events which don't fit are discarded.
+func (c *Conn) StartContext(ctx context.Context, idle Idler) <-chan interface{} {
+	c.ctx = ctx
+
 	// Buffer the event channel to the maximum records a Vantage
 	// Pro 2 console can hold in memory.  This can speed up large
 	// downloads when the receiver is I/O bound with database writes.
+	//
+	// Events are timestamped as they're sent, queued in an internal
+	// channel of the same size, and fanned out from there to the event
+	// channel and any subscriptions.
 	ec := make(chan interface{}, 5*512)
+	in := make(chan interface{})
+	q := make(chan received, 5*512)
+	go stamp(in, q)
+	go c.dispatch(q, ec)
+
+	// Requests left over from before the broker was started were already
+	// failed.
+	c.drain()
+	stopped := make(chan struct{})
+	c.stopped.Store(&stopped)
 
 	go func() (err error) {
-		defer close(ec)
+		defer close(in)
+		defer close(stopped)
+		defer c.drain()
 
 		// Send a console time sync command on startup and every ConsTimeSyncFreq.
 		syncConsTime := time.NewTimer(0)
+		if ConsTimeSyncFreq <= 0 && !syncConsTime.Stop() {
+			<-syncConsTime.C
+		}
 
+		// Poll reception on startup and every RxCheckFreq.
+		rxCheck := time.NewTimer(0)
+		if RxCheckFreq <= 0 && !rxCheck.Stop() {
+			<-rxCheck.C
+		}
+
+		c.setState(ctx, in, StateChange{State: c.State()})
+		attempt := 0
 		for {
+			// Stop if the context is done.  This also prevents resetting
+			// after a command was aborted because of it.
+			if ctx.Err() != nil {
+				Trace.Printf("Stopping command broker: %s", ctx.Err())
+				return ctx.Err()
+			}
+
 			// Before we do anything make sure we're in a non-error state.
 			if err != nil {
-				// Try a soft-reset first.
-				Warn.Printf("%s, trying soft-reset", err.Error())
-				err = c.test()
-				// Hard-reset if we're still in an error state.
-				if err != nil {
+				attempt++
+				if c.ResetRetry.exhausted(attempt) {
+					Error.Printf("%s, giving up after %d attempts", err.Error(), attempt-1)
+					err = fmt.Errorf("%w: %s", ErrRetriesExhausted, err)
+					if c.State() != Disconnected {
+						c.Close()
+					}
+					c.setState(ctx, in, StateChange{State: Disconnected, Err: err, Attempt: attempt - 1})
+					select {
+					case in <- err:
+					case <-ctx.Done():
+					}
+					return
+				}
+
+				// Try a soft-reset first, unless the last hard-reset
+				// couldn't reopen the device.
+				opened := c.State() != Disconnected
+				if opened {
+					Warn.Printf("%s, trying soft-reset", err.Error())
+					c.setState(ctx, in, StateChange{State: SoftReset, Err: err, Attempt: attempt})
+					err = c.test(ctx)
+				}
+				if err == nil {
+					// Only hard-resets count towards the retry policy.
+					c.setState(ctx, in, StateChange{State: Connected, Attempt: attempt})
+					attempt = 0
+					continue
+				}
+
+				// Hard-reset since we're still in an error state.
+				if opened {
 					Error.Printf("%s, trying hard-reset", err.Error())
+					c.setState(ctx, in, StateChange{State: HardReset, Err: err, Attempt: attempt})
 					c.Close()
-					err = c.open()
+					c.setState(ctx, in, StateChange{State: Disconnected, Err: err, Attempt: attempt})
+				}
+				if c.ResetRetry.wait(ctx, attempt) != nil {
+					continue
+				}
+				c.setState(ctx, in, StateChange{State: Reconnecting, Attempt: attempt})
+				err = c.open()
+				if err != nil {
+					Error.Printf("%s, reconnect failed", err.Error())
+					c.setState(ctx, in, StateChange{State: Disconnected, Err: err, Attempt: attempt})
 					continue
 				}
+
+				c.setState(ctx, in, StateChange{State: Connected, Attempt: attempt})
 			}
 
-			// Process command queue channel.
-			Debug.Printf("%d command(s) in queue", len(c.Q))
+			// Process command queue channels.
+			Debug.Printf("%d command(s) in queue", c.pending())
 			select {
+			case <-ctx.Done():
+				continue
 			case cmd := <-c.Q:
-				switch cmd {
-				case GetEEPROM:
-					err = c.GetEEPROM(ec)
-				case GetDmps:
-					c.LastDmp, err = c.GetDmps(ec, c.LastDmp)
-				case GetHiLows:
-					err = c.GetHiLows(ec)
-				case GetLoops:
-					err = c.GetLoops(ec)
-				case LampsOff:
-					err = c.SetLamps(false)
-				case LampsOn:
-					err = c.SetLamps(true)
-				case Stop:
+				if cmd == Stop {
 					return
-				case SyncConsTime:
-					err = c.SyncConsTime()
-				default:
-					// Should never happen unless new commands Cmd*'s are added and
-					// not defined here.
-					Error.Printf("Unhandled command: %d", cmd)
-					err = ErrCmdFailed
 				}
+				_, err = cmd.exec(ctx, c, in)
+			case req := <-c.req:
+				if req.cmd == Stop {
+					req.resp <- response{}
+					return
+				}
+				err = c.run(ctx, req, in)
 			case <-syncConsTime.C:
 				err = c.SyncConsTime()
 				if err != nil {
@@ -254,8 +512,16 @@ func (c *Conn) Start(idle Idler) <-chan interface{} {
 				} else {
 					syncConsTime.Reset(ConsTimeSyncFreq)
 				}
+			case <-rxCheck.C:
+				err = c.pollRx(ctx, in)
+				rxCheck.Reset(RxCheckFreq)
 			default:
-				err = idle(c, ec)
+				err = idle(c, in)
+			}
+			if err == nil {
+				attempt = 0
+			} else if ctx.Err() == nil {
+				c.setState(ctx, in, StateChange{State: CommandFailed, Err: err})
 			}
 		}
 	}()
@@ -263,6 +529,11 @@ func (c *Conn) Start(idle Idler) <-chan interface{} {
 	return ec
 }
 
+// pending returns the number of commands waiting to be run.
+func (c Conn) pending() int {
+	return len(c.Q) + len(c.req)
+}
+
 // Stop stops the command broker.
 func (c Conn) Stop() {
 	Trace.Println("Stopping command broker by request")
@@ -0,0 +1,967 @@
+// Copyright (c) 2016 Eric Barkie. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package weatherlink
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"flag"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/ebarkie/weatherlink/data"
+	"github.com/ebarkie/weatherlink/internal/device"
+	"github.com/stretchr/testify/assert"
+)
+
+// update records the sessions in testdata from the simulated console
+// instead of playing them back, e.g. go test -run TestReset -update.
+var update = flag.Bool("update", false, "record testdata sessions from the simulator")
+
+// replay dials a recorded session and returns the connection and a
+// function which reports if the whole session was played back.  With
+// -update the session is recorded from the simulated console instead, so
+// the test itself drives the console to create it.
+func replay(t *testing.T, name string, s *device.Sim) (Conn, func() bool) {
+	if *update {
+		f, err := os.Create("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+
+		c, err := DialDevice("sim://", Record(s, f))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c, func() bool { return true }
+	}
+
+	c, err := Dial("replay://testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, c.d.(interface{ Done() bool }).Done
+}
+
+// ticker returns a console clock which starts at t and ticks d each time
+// it's read.
+func ticker(t time.Time, d time.Duration) func() time.Time {
+	return func() time.Time {
+		t = t.Add(d)
+		return t
+	}
+}
+
+// sim dials a simulated console whose clock is stopped at the last
+// archive record.
+func sim(t *testing.T, s *device.Sim) Conn {
+	if s.Clock == nil {
+		s.Clock = func() time.Time {
+			return time.Date(2016, time.June, 20, 12, 0, 0, 0, time.Local)
+		}
+	}
+	c, err := DialDevice("sim://", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestGetDmps(t *testing.T) {
+	a := assert.New(t)
+
+	// A console in Eastern time, which was set two days ago so the
+	// records since then are stamped with it, and the last two records.
+	// They're one page starting at the second record with a CRC error the
+	// first time it's sent.
+	last := time.Date(2016, time.June, 20, 19, 0, 0, 0, time.UTC)
+	now := last.Add(-48 * time.Hour)
+	s := &device.Sim{Clock: func() time.Time { return now }}
+	if *update {
+		a.Nil(sim(t, s).SetTimeZone(data.EETimeZone{Zone: 10}))
+	}
+	now = last
+	c, done := replay(t, "dmp.log", s)
+	s.Faults = device.Faults{CRC: 0.5, Rand: rand.New(rand.NewSource(10))}
+	a.Equal("America/New_York", c.ConsLocation().String())
+
+	ec := make(chan interface{}, 5)
+	lastRec, err := c.GetDmps(ec, last.Add(-10*time.Minute))
+	a.Nil(err)
+	a.True(done(), "Session not fully played back")
+
+	var stamps []time.Time
+	for len(ec) > 0 {
+		stamps = append(stamps, (<-ec).(data.Archive).Timestamp.UTC())
+	}
+	a.Equal([]time.Time{last.Add(-5 * time.Minute), last}, stamps)
+	a.True(last.Equal(lastRec), "Last record %s", lastRec)
+}
+
+func TestGetLoops(t *testing.T) {
+	a := assert.New(t)
+
+	// A console which archives every 10 minutes, so 315 loops are
+	// requested, and three loops with the next archive record changing on
+	// the third.  The clock is read once for each of the two EEPROM reads
+	// when the console is dialed, the LPS command, and then once per loop,
+	// so the sixth read is the next record.
+	next := time.Date(2016, time.June, 20, 19, 0, 0, 0, time.UTC)
+	s := &device.Sim{
+		Clock:     func() time.Time { return next.Add(-30 * time.Minute) },
+		LoopDelay: time.Millisecond,
+	}
+	if *update {
+		a.Nil(sim(t, s).SetArchivePeriod(10))
+	}
+	s.Clock = ticker(next.Add(-12*time.Minute), 2*time.Minute)
+	c, done := replay(t, "loops.log", s)
+	a.Equal(10*time.Minute, c.ArchivePeriod())
+
+	ec := make(chan interface{}, 5)
+	err := c.GetLoops(ec)
+	a.Nil(err)
+	a.True(done(), "Session not fully played back")
+
+	a.True(c.NewArcRec)
+	a.Equal(2, len(ec))
+	l := (<-ec).(data.Loop)
+	a.Equal(2, l.LoopType)
+	l = (<-ec).(data.Loop)
+	a.Equal(1, l.LoopType)
+	a.Equal(291, l.NextArcRec)
+}
+
+func TestReset(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(f time.Duration) { ConsTimeSyncFreq = f }(ConsTimeSyncFreq)
+	ConsTimeSyncFreq = 0
+
+	// A failed command, a soft-reset which times out, and then a
+	// successful hard-reset.  Only the soft-reset's TEST stalls.
+	s := &device.Sim{Timeout: 10 * time.Millisecond}
+	c, done := replay(t, "reset.log", s)
+	s.Faults = device.Faults{Stall: 0.5, Rand: rand.New(rand.NewSource(11))}
+	c.CmdRetry = RetryPolicy{MaxAttempts: 1}
+	c.ResetRetry = RetryPolicy{MaxAttempts: 1}
+	sub := c.Subscribe(StateEvent, 16, Block)
+
+	n := 0
+	ec := c.Start(func(c *Conn, _ chan<- interface{}) error {
+		n++
+		if n == 1 {
+			return ErrCmdFailed
+		}
+		c.Q <- Stop
+		return nil
+	})
+	for range ec {
+	}
+	a.True(done(), "Session not fully played back")
+
+	var states []State
+	for e := range sub.C {
+		sc := e.(StateChange)
+		states = append(states, sc.State)
+		if sc.State != Connected && sc.State != CommandFailed {
+			a.Equal(1, sc.Attempt)
+		}
+	}
+	a.Equal([]State{Connected, CommandFailed, SoftReset, HardReset,
+		Disconnected, Reconnecting, Connected}, states)
+	a.Equal(Connected, c.State())
+}
+
+func TestState(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(f time.Duration) { ConsTimeSyncFreq = f }(ConsTimeSyncFreq)
+	ConsTimeSyncFreq = 0
+
+	c := sim(t, &device.Sim{})
+	a.Equal(Connected, c.State())
+	a.Equal("soft-reset", SoftReset.String())
+	a.Equal("State(42)", State(42).String())
+
+	// A failed command which a soft-reset recovers from.  State changes
+	// only go to StateEvent subscribers.
+	s := c.Subscribe(StateEvent, 8, Block)
+	all := c.Subscribe(AllEvents&^StateEvent, 8, Block)
+	n := 0
+	ec := c.Start(func(c *Conn, _ chan<- interface{}) error {
+		n++
+		if n == 1 {
+			return ErrCmdFailed
+		}
+		c.Q <- Stop
+		return nil
+	})
+	for e := range ec {
+		_, ok := e.(StateChange)
+		a.False(ok, "State change sent to event channel")
+	}
+	for e := range all.C {
+		a.NotEqual(StateEvent, kindOf(e))
+	}
+
+	var changes []StateChange
+	for e := range s.C {
+		changes = append(changes, e.(StateChange))
+	}
+	a.Equal([]StateChange{
+		{State: Connected},
+		{State: CommandFailed, Err: ErrCmdFailed},
+		{State: SoftReset, Err: ErrCmdFailed, Attempt: 1},
+		{State: Connected, Attempt: 1},
+	}, changes)
+
+	c.Close()
+	a.Equal(Disconnected, c.State())
+}
+
+func TestRetryPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	tests := []struct {
+		p RetryPolicy
+		n int
+		d time.Duration
+	}{
+		{RetryPolicy{}, 3, 0},
+		{RetryPolicy{InitialDelay: time.Second}, 1, time.Second},
+		{RetryPolicy{InitialDelay: time.Second}, 4, 8 * time.Second},
+		{RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}, 3, 4 * time.Second},
+		{RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}, 4, 5 * time.Second},
+		{RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}, 100, 5 * time.Second},
+		{RetryPolicy{InitialDelay: time.Second}, 35, maxRetryDelay},
+		{RetryPolicy{InitialDelay: time.Second}, 70, maxRetryDelay},
+		{RetryPolicy{InitialDelay: time.Second}, 1 << 30, maxRetryDelay},
+		{RetryPolicy{}, 1 << 30, 0},
+	}
+	for _, test := range tests {
+		a.Equal(test.d, test.p.delay(test.n), "%+v attempt %d", test.p, test.n)
+	}
+
+	p := RetryPolicy{InitialDelay: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		d := p.delay(2)
+		a.True(d >= time.Second && d <= 3*time.Second, "Jittered delay %s", d)
+	}
+
+	p = RetryPolicy{MaxAttempts: 2}
+	a.False(p.exhausted(2))
+	a.True(p.exhausted(3))
+	a.False(RetryPolicy{}.exhausted(1000), "Unlimited attempts")
+}
+
+func TestRegisterDevice(t *testing.T) {
+	a := assert.New(t)
+
+	var got *url.URL
+	RegisterDevice("test", func(u *url.URL) (Device, string, error) {
+		got = u
+		return &device.Sim{}, u.Host, nil
+	})
+	defer func() {
+		devices.Lock()
+		delete(devices.m, "test")
+		devices.Unlock()
+	}()
+
+	c, err := Dial("test://console:22222?x=1")
+	a.NoError(err)
+	a.Equal("console:22222", got.Host)
+	a.Equal("1", got.Query().Get("x"))
+	a.Equal(Connected, c.State())
+	c.Close()
+
+	_, err = Dial("bogus://console")
+	a.ErrorIs(err, ErrUnknownDevice)
+}
+
+func TestSerialBaud(t *testing.T) {
+	a := assert.New(t)
+
+	d, _, err := newDevice("/dev/ttyUSB0?baud=2400")
+	a.NoError(err)
+	a.Equal(2400, d.(*device.Serial).Baud)
+
+	for _, baud := range []string{"fast", "-5", "0", "115200"} {
+		_, _, err = newDevice("/dev/ttyUSB0?baud=" + baud)
+		a.ErrorIs(err, ErrInvalidArg, "Baud %s", baud)
+	}
+}
+
+func TestRecordDevice(t *testing.T) {
+	a := assert.New(t)
+
+	// A recorded serial device's baud rate can still be changed.
+	var b bytes.Buffer
+	d, ok := Record(&device.Serial{}, &b).(interface {
+		GetBaud() int
+		SetBaud(int) error
+	})
+	if !ok {
+		t.Fatal("Recorded serial device has no baud rate")
+	}
+	a.Equal(device.DefaultBaud, d.GetBaud())
+	a.Nil(d.SetBaud(2400))
+	a.Equal(2400, d.GetBaud())
+
+	// The simulator has neither so the recording doesn't either.
+	s := Record(&device.Sim{}, &b)
+	err := s.(interface{ SetReadDeadline(time.Time) error }).SetReadDeadline(time.Now())
+	a.ErrorIs(err, ErrNotSupported)
+	c, err := DialDevice("sim://", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.ErrorIs(c.SetBaud(2400), ErrNotSupported)
+	a.NotContains(b.String(), hex.EncodeToString([]byte("BAUD")))
+}
+
+func TestStdIdle(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(f time.Duration) { ConsTimeSyncFreq = f }(ConsTimeSyncFreq)
+	ConsTimeSyncFreq = 0
+
+	// A simulated console whose clock runs fast enough for a new
+	// archive record to be written during the loop stream.
+	now := time.Date(2016, time.June, 20, 12, 0, 0, 0, time.Local)
+	c := sim(t, &device.Sim{
+		Clock: func() time.Time {
+			now = now.Add(10 * time.Second)
+			return now
+		},
+		LoopDelay: time.Millisecond,
+	})
+
+	ec := c.Start(func(c *Conn, ec chan<- interface{}) error {
+		if !c.LastDmp.IsZero() {
+			c.Q <- Stop
+			return nil
+		}
+		return StdIdle(c, ec)
+	})
+	loops, arcs := 0, 0
+	var prev time.Time
+	for e := range ec {
+		switch e := e.(type) {
+		case data.Loop:
+			a.Equal(0, arcs, "Loop after archive records")
+			loops++
+		case data.Archive:
+			a.True(e.Timestamp.After(prev), "Archive records out of order")
+			prev = e.Timestamp
+			arcs++
+		}
+	}
+	a.True(loops > 0)
+	// A day of records plus the new one.
+	a.Equal(24*12+1, arcs)
+	a.Equal(prev, c.LastDmp)
+
+	pc := make(chan interface{}, 2)
+	ee, err := c.getEEPROM(context.Background(), pc)
+	a.Nil(err)
+	a.Equal(5, ee.ArchivePeriod)
+	a.Equal(37.9, ee.Lat)
+	a.Equal(-122.1, ee.Lon)
+
+	hl, err := c.getHiLows(context.Background(), pc)
+	a.Nil(err)
+	a.True(hl.OutTemp.Day.Hi >= hl.OutTemp.Day.Low)
+	a.Equal(40, hl.InHumidity.Day.Hi)
+}
+
+func TestDo(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(f time.Duration) { ConsTimeSyncFreq = f }(ConsTimeSyncFreq)
+	ConsTimeSyncFreq = 0
+
+	c := sim(t, &device.Sim{})
+	_, err := c.Do(context.Background(), GetConsTime)
+	a.Equal(ErrStopped, err, "Broker not started")
+
+	ec := c.Start(func(*Conn, chan<- interface{}) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	r, err := c.Do(context.Background(), GetConsTime)
+	a.Nil(err)
+	a.IsType(time.Time{}, r)
+
+	c.Stop()
+	for range ec {
+	}
+	_, err = c.Do(context.Background(), GetConsTime)
+	a.Equal(ErrStopped, err, "Broker stopped")
+}
+
+// nextArcRecs returns the NextArcRec of the loops in a subscription.
+func nextArcRecs(s *Subscription) (n []int) {
+	for e := range s.C {
+		n = append(n, e.(data.Loop).NextArcRec)
+	}
+
+	return
+}
+
+func TestSubscribe(t *testing.T) {
+	a := assert.New(t)
+
+	c := Conn{subs: newHub()}
+	oldest := c.Subscribe(LoopEvent, 2, DropOldest)
+	newest := c.Subscribe(LoopEvent, 2, DropNewest)
+	unbuffered := c.Subscribe(AllEvents, 0, DropOldest)
+	arcs := c.Subscribe(ArchiveEvent, 1, DropNewest)
+
+	// Nothing reads the subscriptions or the event channel while the
+	// events are dispatched.
+	q := make(chan received, 4)
+	for i := 1; i <= 4; i++ {
+		q <- received{t: time.Now(), e: data.Loop{NextArcRec: i}}
+	}
+	close(q)
+	c.dispatch(q, make(chan interface{}, 1))
+
+	a.Equal([]int{3, 4}, nextArcRecs(oldest), "Drop oldest")
+	a.Equal(uint64(2), oldest.Dropped())
+	a.Equal([]int{1, 2}, nextArcRecs(newest), "Drop newest")
+	a.Equal(uint64(2), newest.Dropped())
+	a.Equal([]int{4}, nextArcRecs(unbuffered), "Buffer size of 0")
+	a.Equal(uint64(3), unbuffered.Dropped())
+	a.Empty(nextArcRecs(arcs), "Filtered")
+}
+
+func TestSubscribeBlock(t *testing.T) {
+	a := assert.New(t)
+
+	// A blocking subscriber gets every event even though it reads slowly
+	// and the event channel isn't read at all.
+	c := Conn{subs: newHub()}
+	s := c.Subscribe(LoopEvent, 1, Block)
+	ec := make(chan interface{}, 1)
+	q := make(chan received, 3)
+	for i := 0; i < 3; i++ {
+		q <- received{t: time.Now(), e: data.Loop{NextArcRec: i}}
+	}
+	close(q)
+	go c.dispatch(q, ec)
+
+	var got []int
+	for e := range s.C {
+		time.Sleep(time.Millisecond)
+		got = append(got, e.(data.Loop).NextArcRec)
+	}
+	a.Equal([]int{0, 1, 2}, got)
+	a.Equal(uint64(0), s.Dropped())
+	a.Equal(1, len(ec), "Event channel overflow discarded")
+}
+
+func TestStartArchive(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(f time.Duration) { ConsTimeSyncFreq = f }(ConsTimeSyncFreq)
+	ConsTimeSyncFreq = 0
+
+	// The event channel is full of loops during the download and isn't
+	// read until the broker has stopped.
+	const fill = 5*512 + 100
+	c := sim(t, &device.Sim{})
+	n := 0
+	ec := c.Start(func(c *Conn, ec chan<- interface{}) (err error) {
+		n++
+		if n > 1 {
+			c.Q <- Stop
+			return
+		}
+		for i := 0; i < fill; i++ {
+			ec <- data.Loop{}
+		}
+		c.LastDmp, err = c.GetDmps(ec, time.Time{})
+		return
+	})
+	<-c.brokerStopped()
+	time.Sleep(10 * time.Millisecond)
+
+	loops, arcs := 0, 0
+	for e := range ec {
+		switch e.(type) {
+		case data.Loop:
+			loops++
+		case data.Archive:
+			arcs++
+		}
+	}
+	a.Less(loops, fill, "Loops discarded")
+	a.Equal(24*12, arcs, "Archive records")
+}
+
+func TestEnvelope(t *testing.T) {
+	a := assert.New(t)
+
+	c := Conn{addr: "sim://", subs: newHub(), Envelope: true}
+	s := c.Subscribe(AllEvents, 1, Block)
+	in := make(chan interface{})
+	q := make(chan received, 3)
+	go stamp(in, q)
+	go c.dispatch(q, make(chan interface{}, 3))
+
+	// The events are timestamped when they're sent even though they're
+	// queued behind the blocked subscriber.
+	start := time.Now()
+	in <- data.Loop{}
+	in <- data.Archive{}
+	in <- ErrCmdFailed
+	sent := time.Now()
+	close(in)
+	time.Sleep(10 * time.Millisecond)
+
+	var kinds []EventKind
+	for e := range s.C {
+		ev := e.(Event)
+		a.Equal("sim://", ev.Station)
+		a.Equal(uint64(len(kinds)+1), ev.Seq, "Sequence number")
+		a.False(ev.Received.Before(start) || ev.Received.After(sent), "Received time")
+		a.Equal(ev.Kind, kindOf(ev.Payload))
+		kinds = append(kinds, ev.Kind)
+	}
+	a.Equal([]EventKind{LoopEvent, ArchiveEvent, ErrorEvent}, kinds)
+}
+
+func TestFaultAck(t *testing.T) {
+	a := assert.New(t)
+
+	d := &device.Sim{}
+	c := sim(t, d)
+	d.Faults = device.Faults{BadAck: 1}
+	c.CmdRetry = RetryPolicy{MaxAttempts: 1}
+	_, err := c.GetConsTime()
+	a.Equal(ErrCmdFailed, err)
+
+	// A policy without a limit still gives up on a dead device.
+	var b bytes.Buffer
+	d.Faults = device.Faults{}
+	c, err = DialDevice("sim://", Record(d, &b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Faults = device.Faults{BadAck: 1}
+	c.CmdRetry = RetryPolicy{}
+	_, err = c.GetConsTime()
+	a.Equal(ErrCmdFailed, err)
+	a.Equal(cmdAttempts, strings.Count(b.String(), " w "+hex.EncodeToString([]byte("GETTIME\n"))))
+}
+
+func TestFaultDmps(t *testing.T) {
+	a := assert.New(t)
+
+	// Bad pages are retried and, if the download is aborted because of
+	// bad metadata, it resumes from the last record read.
+	c := sim(t, &device.Sim{Faults: device.Faults{
+		CRC:  0.3,
+		Rand: rand.New(rand.NewSource(1)),
+	}})
+	ec := make(chan interface{}, 5*512)
+	var lastRec time.Time
+	for i := 0; i < 10; i++ {
+		var err error
+		if lastRec, err = c.GetDmps(ec, lastRec); err == nil {
+			break
+		}
+	}
+
+	a.Equal(24*12, len(ec))
+	var prev time.Time
+	for len(ec) > 0 {
+		arc := (<-ec).(data.Archive)
+		a.True(arc.Timestamp.After(prev), "Archive records out of order")
+		prev = arc.Timestamp
+	}
+	a.Equal(prev, lastRec)
+}
+
+func TestFaultLoops(t *testing.T) {
+	a := assert.New(t)
+
+	// The stream is aborted at the first bad loop.
+	d := &device.Sim{}
+	c := sim(t, d)
+	d.Faults = device.Faults{CRC: 1}
+	ec := make(chan interface{}, 5)
+	err := c.GetLoops(ec)
+	a.Equal(data.ErrBadCRC, err)
+	a.Equal(0, len(ec))
+}
+
+func TestFaultReset(t *testing.T) {
+	a := assert.New(t)
+
+	defer func(f time.Duration) { ConsTimeSyncFreq = f }(ConsTimeSyncFreq)
+	ConsTimeSyncFreq = 0
+
+	// A console which never answers exhausts the hard-resets.
+	d := &device.Sim{}
+	c := sim(t, d)
+	d.Faults = device.Faults{Stall: 1}
+	c.CmdRetry = RetryPolicy{MaxAttempts: 1}
+	c.ResetRetry = RetryPolicy{MaxAttempts: 1}
+	s := c.Subscribe(StateEvent, 32, Block)
+
+	var err error
+	for e := range c.Start(StdIdle) {
+		err, _ = e.(error)
+	}
+	a.ErrorIs(err, ErrRetriesExhausted)
+
+	var states []State
+	for e := range s.C {
+		states = append(states, e.(StateChange).State)
+	}
+	a.Equal([]State{Connected, CommandFailed, SoftReset, HardReset,
+		Disconnected, Reconnecting, Connected, CommandFailed, Disconnected}, states)
+	a.Equal(Disconnected, c.State())
+}
+
+func TestWriteEEPROM(t *testing.T) {
+	a := assert.New(t)
+
+	c := sim(t, &device.Sim{})
+	a.ErrorIs(c.WriteEEPROM(eeSize-1, []byte{0, 0}), ErrInvalidArg)
+	a.Nil(c.WriteEEPROM(0x2c, []byte{7}))
+	p, err := c.ReadEEPROMRange(0x2c, 1)
+	a.Nil(err)
+	a.Equal([]byte{7}, p)
+
+	a.ErrorIs(c.SetArchivePeriod(7), ErrInvalidArg)
+	a.Nil(c.SetArchivePeriod(10))
+	a.Equal(10*time.Minute, c.ArchivePeriod())
+
+	a.Nil(c.SetLocation(-33.9, 18.4))
+	a.Nil(c.SetElevation(150))
+	tz := data.EETimeZone{Offset: 2 * time.Hour, UseOffset: true}
+	a.Nil(c.SetTimeZone(tz))
+	temp, hum := 0, 1
+	var stations [8]data.EEStation
+	for i := range stations {
+		stations[i].Type = "None"
+	}
+	stations[0] = data.EEStation{Active: true, Type: "ISS"}
+	stations[2] = data.EEStation{Active: true, HumSensor: &hum, Repeater: "B", TempSensor: &temp, Type: "Temp/Hum"}
+	a.Nil(c.SetStations(stations, 0))
+
+	ee, err := c.getEEPROM(context.Background(), make(chan interface{}, 1))
+	a.Nil(err)
+	a.Equal(time.July, ee.RainSeasonStart)
+	a.Equal(10, ee.ArchivePeriod)
+	a.Equal(-33.9, ee.Lat)
+	a.Equal(18.4, ee.Lon)
+	a.Equal(150, ee.Elev)
+	a.True(ee.TimeZone.UseOffset)
+	a.Equal(2*time.Hour, ee.TimeZone.Offset)
+	for i := range stations {
+		stations[i].ID = i + 1
+	}
+	a.Equal(stations, ee.Stations)
+}
+
+func TestCal(t *testing.T) {
+	a := assert.New(t)
+
+	c := sim(t, &device.Sim{})
+	cal := data.EECal{InTemp: -1.5, OutTemp: 0.3, OutHumidity: -2, WindDir: 10}
+	cal.ExtraTemp[1] = 1.2
+	a.Nil(c.SetCal(cal))
+	got, err := c.GetCal()
+	a.Nil(err)
+	a.Equal(cal, got)
+
+	a.Nil(c.ClearCal(Confirm))
+	got, err = c.GetCal()
+	a.Nil(err)
+	a.Equal(data.EECal{WindDir: 10}, got)
+
+	a.ErrorIs(c.SetBarCal(40.0, 0), ErrInvalidArg)
+	a.Nil(c.SetBarCal(0, 620))
+	bd, err := c.GetBarData()
+	a.Nil(err)
+	a.Equal(620, bd.Elev)
+	a.Equal(0.0, bd.BarCal)
+
+	// Changing the elevation keeps the barometer calibration.
+	a.Nil(c.SetBarCal(29.5, 620))
+	bd, err = c.GetBarData()
+	a.Nil(err)
+	a.NotEqual(0.0, bd.BarCal)
+	a.ErrorIs(c.SetElevation(20000), ErrInvalidArg)
+	a.Nil(c.SetElevation(700))
+	bd2, err := c.GetBarData()
+	a.Nil(err)
+	a.Equal(700, bd2.Elev)
+	a.Equal(bd.BarCal, bd2.BarCal)
+}
+
+func TestAlarms(t *testing.T) {
+	a := assert.New(t)
+
+	c := sim(t, &device.Sim{LoopDelay: time.Millisecond})
+	hi, rate := -40, 1.5
+	a.Nil(c.SetAlarms(data.EEAlarms{OutTempHi: &hi, RainRate: &rate}))
+	al, err := c.GetAlarms()
+	a.Nil(err)
+	a.Equal(hi, *al.OutTempHi)
+	a.InDelta(rate, *al.RainRate, 0.001)
+	a.Nil(al.OutTempLow)
+
+	// The outside temperature is always above the threshold.
+	ec := make(chan interface{}, 5)
+	a.Nil(c.GetLoops(ec))
+	a.True(len(ec) > 0)
+	a.Equal(data.LoopAlarms{OutTempHi: true}, (<-ec).(data.Loop).Alarms)
+
+	a.Nil(c.ClearAlarms(Confirm))
+	al, err = c.GetAlarms()
+	a.Nil(err)
+	a.Equal(data.EEAlarms{}, al)
+}
+
+func TestClear(t *testing.T) {
+	a := assert.New(t)
+
+	c := sim(t, &device.Sim{})
+	a.ErrorIs(c.ClearHighs(Daily, false), ErrNotConfirmed)
+	a.ErrorIs(c.ClearLog(false), ErrNotConfirmed)
+	a.ErrorIs(c.ClearHighs(Yearly+1, Confirm), ErrInvalidArg)
+	a.ErrorIs(c.ClearAccum(15, Confirm), ErrInvalidArg)
+
+	for _, p := range []Period{Daily, Monthly, Yearly} {
+		a.Nil(c.ClearHighs(p, Confirm))
+		a.Nil(c.ClearLows(p, Confirm))
+	}
+	for _, acc := range []Accum{DayRain, StormRain, MonthRain, YearRain, DayET, MonthET, YearET} {
+		a.Nil(c.ClearAccum(acc, Confirm))
+	}
+	a.Nil(c.ClearGraphs(Confirm))
+
+	// Nothing is left to download once the archive memory is cleared.
+	a.Nil(c.ClearLog(Confirm))
+	ec := make(chan interface{}, 1)
+	_, err := c.GetDmps(ec, time.Time{})
+	a.Nil(err)
+	a.Equal(0, len(ec))
+}
+
+func TestRx(t *testing.T) {
+	a := assert.New(t)
+
+	// Noon in the console time zone.
+	pacific, _ := time.LoadLocation("America/Los_Angeles")
+	c := sim(t, &device.Sim{Clock: func() time.Time {
+		return time.Date(2016, time.June, 20, 12, 0, 0, 0, pacific)
+	}})
+	rs, err := c.GetRxCheck()
+	a.Nil(err)
+	a.Equal(12*60*60*2/5, rs.Received)
+	a.Equal(0, rs.Missed)
+
+	rcv, err := c.GetReceivers()
+	a.Nil(err)
+	a.Equal(data.Receivers{1}, rcv)
+
+	// The broker polls reception on startup.
+	defer func(f time.Duration) { ConsTimeSyncFreq = f }(ConsTimeSyncFreq)
+	ConsTimeSyncFreq = 0
+	defer func(f time.Duration) { RxCheckFreq = f }(RxCheckFreq)
+	RxCheckFreq = time.Hour
+
+	s := c.Subscribe(RxCheckEvent|ReceiversEvent, 2, Block)
+	ec := c.Start(func(*Conn, chan<- interface{}) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	a.Equal(rs, <-s.C)
+	a.Equal(rcv, <-s.C)
+	c.Stop()
+	for range ec {
+	}
+}
+
+func TestPutAccum(t *testing.T) {
+	a := assert.New(t)
+
+	c := sim(t, &device.Sim{LoopDelay: time.Millisecond})
+	a.ErrorIs(c.PutRain(-1), ErrInvalidArg)
+	a.ErrorIs(c.PutET(1000), ErrInvalidArg)
+	a.Nil(c.PutRain(24.83))
+	a.Nil(c.PutET(12.5))
+
+	ec := make(chan interface{}, 5)
+	a.Nil(c.GetLoops(ec))
+	for len(ec) > 0 {
+		l := (<-ec).(data.Loop)
+		if l.LoopType == 1 {
+			a.Equal(24.83, l.Rain.Accum.LastYear)
+			a.Equal(12.5, l.ET.LastYear)
+		}
+	}
+}
+
+func TestGetDmpsDST(t *testing.T) {
+	a := assert.New(t)
+
+	// A day of archive records across daylight savings ending, so an hour
+	// of local times were written twice.
+	pacific, _ := time.LoadLocation("America/Los_Angeles")
+	now := time.Date(2016, time.November, 6, 12, 0, 0, 0, pacific)
+	c := sim(t, &device.Sim{Clock: func() time.Time { return now }})
+	a.Equal("America/Los_Angeles", c.ConsLocation().String())
+
+	ct, err := c.GetConsTime()
+	a.Nil(err)
+	a.True(now.Equal(ct), "Console time")
+
+	ec := make(chan interface{}, 5*512)
+	lastRec, err := c.GetDmps(ec, time.Time{})
+	a.Nil(err)
+	a.Equal(24*12, len(ec))
+	prev := (<-ec).(data.Archive).Timestamp
+	for len(ec) > 0 {
+		arc := (<-ec).(data.Archive)
+		a.Equal(5*time.Minute, arc.Timestamp.Sub(prev), "Archive records out of order")
+		prev = arc.Timestamp
+	}
+	a.Equal(now.UTC(), lastRec)
+
+	// Download after the last record before the clock was set back.
+	lastRec, err = c.GetDmps(ec, time.Date(2016, time.November, 6, 8, 55, 0, 0, time.UTC))
+	a.Nil(err)
+	a.Equal(11*12+1, len(ec))
+	a.Equal(time.Date(2016, time.November, 6, 9, 0, 0, 0, time.UTC), (<-ec).(data.Archive).Timestamp)
+	a.Equal(now.UTC(), lastRec)
+
+	// The console time zone can be set rather than read from the EEPROM.
+	c.ConsLoc = time.UTC
+	a.Equal(time.UTC, c.ConsLocation())
+}
+
+func TestDumpArchive(t *testing.T) {
+	a := assert.New(t)
+
+	// No records are created for the hour archiving is stopped.
+	now := time.Date(2016, time.June, 20, 12, 0, 0, 0, time.Local)
+	c := sim(t, &device.Sim{Clock: func() time.Time { return now }})
+	a.Nil(c.StopArchiving())
+	now = now.Add(time.Hour)
+	a.Nil(c.StartArchiving())
+	now = now.Add(time.Hour)
+
+	var progress []DmpProgress
+	ec := make(chan interface{}, 5*512)
+	n, err := c.DumpArchive(ec, func(dp DmpProgress) {
+		progress = append(progress, dp)
+	})
+	a.Nil(err)
+	a.Equal(24*12+12, n)
+	a.Equal(n, len(ec))
+	a.Equal(512, len(progress))
+	a.Equal(DmpProgress{Pages: 512, Total: 512, Records: n}, progress[511])
+
+	var prev time.Time
+	for len(ec) > 0 {
+		arc := (<-ec).(data.Archive)
+		a.True(arc.Timestamp.After(prev), "Archive records out of order")
+		a.False(arc.Timestamp.After(now.Add(-2*time.Hour)) && !arc.Timestamp.After(now.Add(-time.Hour)),
+			"Archive record while stopped")
+		prev = arc.Timestamp
+	}
+	a.Equal(now.UTC(), prev)
+}
+
+func TestResumeDmps(t *testing.T) {
+	a := assert.New(t)
+
+	// Interrupt a download part way through a page and resume it.
+	interrupt := func(c Conn, ec chan interface{}, full bool) DmpToken {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			for len(ec) < 7 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+
+		var err error
+		if full {
+			_, err = c.DumpArchiveContext(ctx, ec, nil)
+		} else {
+			_, err = c.GetDmpsContext(ctx, ec, time.Time{})
+		}
+		var de *DmpError
+		a.ErrorAs(err, &de)
+		a.ErrorIs(err, context.Canceled)
+		a.Equal(full, de.Token.Full)
+		a.Equal(1, de.Token.Page)
+		a.Equal(len(ec)-de.Token.Page, de.Token.Records)
+
+		return de.Token
+	}
+
+	for _, full := range []bool{false, true} {
+		c := sim(t, &device.Sim{})
+		c.ProgressEvents = true
+		ec := make(chan interface{}, 7)
+		tok := interrupt(c, ec, full)
+		interrupted := tok.Page
+
+		var progress []DmpProgress
+		rc := make(chan interface{}, 5*512+512)
+		tok, err := c.ResumeDmps(rc, tok, func(dp DmpProgress) {
+			progress = append(progress, dp)
+		})
+		a.Nil(err)
+		a.Equal(24*12, tok.Records)
+		a.Equal(progress[len(progress)-1], DmpProgress{Pages: tok.Page, Total: tok.Page, Records: 24 * 12})
+
+		// Every record once, oldest to newest, with a progress event
+		// after each page.
+		var prev time.Time
+		pages := 0
+		for _, e := range append(drain(ec), drain(rc)...) {
+			switch e := e.(type) {
+			case data.Archive:
+				a.True(e.Timestamp.After(prev), "Archive records out of order")
+				prev = e.Timestamp
+			case DmpProgress:
+				pages++
+			}
+		}
+		a.Equal(tok.LastRec, prev)
+		a.Equal(interrupted+len(progress), pages)
+	}
+}
+
+// drain returns the events buffered in an event channel.
+func drain(ec chan interface{}) (events []interface{}) {
+	for len(ec) > 0 {
+		events = append(events, <-ec)
+	}
+
+	return
+}